@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliverkofoed/gokit/sitekit/web"
+	"github.com/spf13/cobra"
+)
+
+var configPath string
+var report bool
+var markdown bool
+
+func Main() {
+	main()
+}
+
+func main() {
+	// build cobra-command tree
+	SitekitCmd.AddCommand(SitekitBuildCommand)
+	SitekitCmd.AddCommand(SitekitDiffCommand)
+
+	SitekitCmd.PersistentFlags().StringVar(&configPath, "config", "sitekit.json", "path to the asset configuration")
+	SitekitBuildCommand.Flags().BoolVar(&report, "report", false, "print how long building each asset took")
+	SitekitDiffCommand.Flags().BoolVar(&markdown, "markdown", false, "print the diff as a markdown table")
+
+	// run
+	SitekitCmd.SilenceErrors = true
+	SitekitCmd.SilenceUsage = true
+	if err := SitekitCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+		os.Exit(-1)
+	}
+}
+
+// SitekitCmd represents the base command when called without any subcommands
+var SitekitCmd = &cobra.Command{
+	Use:   "sitekit",
+	Short: "Builds sitekit assets without running the web server",
+}
+
+// SitekitBuildCommand represents the 'sitekit build' command
+var SitekitBuildCommand = &cobra.Command{
+	Use:     "build",
+	Short:   "Processes all assets and writes them, their compressed variants and manifest.json to a directory",
+	Example: "sitekit build --config sitekit.json dist",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		assets, err := loadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		result, err := assets.BuildAll(context.Background())
+		if err != nil {
+			return err
+		}
+		if report {
+			fmt.Print(result)
+		}
+		if err := assets.Export(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %v assets to %v\n", len(result.Assets), args[0])
+		return nil
+	},
+}
+
+// SitekitDiffCommand represents the 'sitekit diff' command
+var SitekitDiffCommand = &cobra.Command{
+	Use:     "diff",
+	Short:   "Lists the assets added, removed and changed between two manifests",
+	Example: "sitekit diff --markdown base/manifest.json dist/manifest.json",
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		old, err := readManifest(args[0])
+		if err != nil {
+			return err
+		}
+		new, err := readManifest(args[1])
+		if err != nil {
+			return err
+		}
+		diff := web.DiffManifests(old, new)
+		if markdown {
+			fmt.Print(diff.Markdown())
+		} else {
+			fmt.Print(diff)
+		}
+		return nil
+	},
+}
+
+func readManifest(path string) (web.Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := web.ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// config describes the assets to build. Relative paths on disk are relative
+// to the config file.
+type config struct {
+	BaseURL     string              `json:"baseURL"`
+	Mode        string              `json:"mode"`        // "development" or "production"
+	URLFormat   string              `json:"urlFormat"`   // "hash", "namedhash" or "query"
+	Directories map[string]string   `json:"directories"` // virtual directory to directory on disk
+	Files       map[string]string   `json:"files"`       // virtual path to file on disk
+	Bundles     map[string][]string `json:"bundles"`     // virtual path to members
+	Scss        bool                `json:"scss"`        // compile .scss with sass
+	SourceMaps  string              `json:"sourceMaps"`  // "public", "private" or "strip"; only public maps are exported
+}
+
+func loadConfig(path string) (*web.Assets, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = "/a/"
+	}
+
+	assets := web.NewAssets(c.BaseURL)
+	switch c.Mode {
+	case "", "production":
+		assets.Mode = web.ModeProduction
+	case "development":
+		assets.Mode = web.ModeDevelopment
+	default:
+		return nil, fmt.Errorf("%v: unknown mode %q", path, c.Mode)
+	}
+	switch c.URLFormat {
+	case "", "hash":
+		assets.URLFormat = web.URLHash
+	case "namedhash":
+		assets.URLFormat = web.URLNamedHash
+	case "query":
+		assets.URLFormat = web.URLQuery
+	default:
+		return nil, fmt.Errorf("%v: unknown url format %q", path, c.URLFormat)
+	}
+	switch c.SourceMaps {
+	case "", "public":
+		assets.SourceMaps = web.SourceMapsPublic
+	case "private":
+		assets.SourceMaps = web.SourceMapsPrivate
+	case "strip":
+		assets.SourceMaps = web.SourceMapsStrip
+	default:
+		return nil, fmt.Errorf("%v: unknown source map policy %q", path, c.SourceMaps)
+	}
+	if c.Scss {
+		assets.AddScssPreprocessor("")
+	}
+
+	root := filepath.Dir(path)
+	onDisk := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(root, name)
+	}
+	for virtualPath, directory := range c.Directories {
+		if !strings.HasSuffix(virtualPath, "/") {
+			virtualPath += "/"
+		}
+		if err := assets.AddDirectory(onDisk(directory), virtualPath); err != nil {
+			return nil, err
+		}
+	}
+	for virtualPath, file := range c.Files {
+		assets.AddFile(onDisk(file), virtualPath)
+	}
+	for virtualPath, members := range c.Bundles {
+		assets.Bundle(virtualPath, members...)
+	}
+	return assets, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oliverkofoed/gokit/sitekit/web"
+	"github.com/oliverkofoed/gokit/testkit"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitekit")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+	write("site/css/site.css", "body{margin:0}")
+	write("site/js/app.js", "console.log(1);")
+	absolute := write("other/logo.svg", "<svg></svg>")
+
+	tests := []struct {
+		config     string
+		err        string
+		mode       web.Mode
+		urlFormat  web.URLFormat
+		sourceMaps web.SourceMapPolicy
+		paths      []string
+	}{
+		{config: `{}`, mode: web.ModeProduction, urlFormat: web.URLHash, sourceMaps: web.SourceMapsPublic},
+		{config: `{"mode": "development", "urlFormat": "namedhash", "sourceMaps": "private"}`, mode: web.ModeDevelopment, urlFormat: web.URLNamedHash, sourceMaps: web.SourceMapsPrivate},
+		{config: `{"mode": "production", "urlFormat": "query", "sourceMaps": "strip"}`, mode: web.ModeProduction, urlFormat: web.URLQuery, sourceMaps: web.SourceMapsStrip},
+		{
+			config:     `{"directories": {"/css": "site/css"}, "files": {"/js/app.js": "site/js/app.js", "/logo.svg": "` + filepath.ToSlash(absolute) + `"}, "bundles": {"/all.css": ["/css/site.css"]}}`,
+			mode:       web.ModeProduction,
+			urlFormat:  web.URLHash,
+			sourceMaps: web.SourceMapsPublic,
+			paths:      []string{"/all.css", "/css/site.css", "/js/app.js", "/logo.svg"},
+		},
+		{config: `{"mode": "staging"}`, err: `unknown mode "staging"`},
+		{config: `{"urlFormat": "path"}`, err: `unknown url format "path"`},
+		{config: `{"sourceMaps": "hidden"}`, err: `unknown source map policy "hidden"`},
+		{config: `{"directories": {"/css": "missing"}}`, err: "missing"},
+		{config: `{"mode": 1}`, err: "sitekit.json"},
+	}
+	for _, test := range tests {
+		assets, err := loadConfig(write("sitekit.json", test.config))
+		if test.err != "" {
+			testkit.Assert(t, err != nil && strings.Contains(err.Error(), test.err))
+			continue
+		}
+		testkit.NoError(t, err)
+		testkit.Equal(t, assets.Mode, test.mode)
+		testkit.Equal(t, assets.URLFormat, test.urlFormat)
+		testkit.Equal(t, assets.SourceMaps, test.sourceMaps)
+		if test.paths != nil {
+			testkit.Equal(t, assets.Paths(), test.paths)
+			for _, virtualPath := range test.paths {
+				_, err := assets.Get(virtualPath)
+				testkit.NoError(t, err)
+			}
+		}
+	}
+
+	_, err = loadConfig(filepath.Join(dir, "missing.json"))
+	testkit.Assert(t, err != nil)
+}
+
+func TestBuildDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitekit")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) {
+		testkit.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("sitekit.json", `{"directories": {"/": "site"}}`)
+	write("site/css/site.css", "body{margin:0}")
+	write("site/js/app.js", "console.log(1);")
+
+	configPath = filepath.Join(dir, "sitekit.json")
+	testkit.NoError(t, SitekitBuildCommand.RunE(SitekitBuildCommand, []string{filepath.Join(dir, "base")}))
+	write("site/js/app.js", "console.log(2);")
+	write("site/js/extra.js", "console.log(3);")
+	testkit.NoError(t, SitekitBuildCommand.RunE(SitekitBuildCommand, []string{filepath.Join(dir, "head")}))
+
+	base, err := readManifest(filepath.Join(dir, "base", "manifest.json"))
+	testkit.NoError(t, err)
+	head, err := readManifest(filepath.Join(dir, "head", "manifest.json"))
+	testkit.NoError(t, err)
+	diff := web.DiffManifests(base, head)
+	testkit.Equal(t, len(diff.Added), 1)
+	testkit.Equal(t, diff.Added[0].VirtualPath, "/js/extra.js")
+	testkit.Equal(t, len(diff.Removed), 0)
+	testkit.Equal(t, len(diff.Changed), 1)
+	testkit.Equal(t, diff.Changed[0].VirtualPath, "/js/app.js")
+
+	// the exported files are where the manifest says
+	for _, entry := range head {
+		_, err := os.Stat(filepath.Join(dir, "head", filepath.FromSlash(strings.TrimPrefix(entry.URL, "/a/"))))
+		testkit.NoError(t, err)
+	}
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// SizeBudget limits the size of the files matching Pattern, e.g.
+// {Pattern: "/bundles/app.js", MaxSize: 200 << 10, Gzipped: true}.
+type SizeBudget struct {
+	Pattern string // glob of virtual paths, see Glob
+	MaxSize int    // bytes
+	Gzipped bool   // measure the gzipped size rather than the processed size
+}
+
+// BudgetResult is the size of a file measured against a budget.
+type BudgetResult struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Size    int    `json:"size"`
+	MaxSize int    `json:"maxSize"`
+	Gzipped bool   `json:"gzipped"`
+}
+
+// Exceeded reports whether the file is over the budget.
+func (r BudgetResult) Exceeded() bool {
+	return r.Size > r.MaxSize
+}
+
+func (r BudgetResult) String() string {
+	measure := "bytes"
+	if r.Gzipped {
+		measure = "bytes gzipped"
+	}
+	return fmt.Sprintf("%v is %v %v, budget %v", r.Path, r.Size, measure, r.MaxSize)
+}
+
+// BudgetReport holds a result for every file and budget matching it.
+type BudgetReport []BudgetResult
+
+// Exceeded returns the results over budget.
+func (r BudgetReport) Exceeded() BudgetReport {
+	var exceeded BudgetReport
+	for _, result := range r {
+		if result.Exceeded() {
+			exceeded = append(exceeded, result)
+		}
+	}
+	return exceeded
+}
+
+// BudgetError is returned by BuildAll when EnforceBudgets is set and files
+// are over budget.
+type BudgetError struct {
+	Report BudgetReport // the results over budget
+}
+
+func (e *BudgetError) Error() string {
+	messages := make([]string, len(e.Report))
+	for i, result := range e.Report {
+		messages[i] = result.String()
+	}
+	return "size budget exceeded: " + strings.Join(messages, "; ")
+}
+
+// CheckBudgets measures the files matching Budgets. Files are loaded as
+// needed.
+func (f *Assets) CheckBudgets() (BudgetReport, error) {
+	var report BudgetReport
+	for _, budget := range f.Budgets {
+		matches, err := f.Glob(budget.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, virtualPath := range matches {
+			file, err := f.Get(virtualPath)
+			if err != nil {
+				return nil, err
+			}
+
+			size := len(file.Content)
+			if budget.Gzipped {
+				gzipped := file.ContentGZipped
+				if gzipped == nil {
+					if gzipped, err = f.Compression.gzip(file.Content); err != nil {
+						return nil, err
+					}
+				}
+				size = len(gzipped)
+			}
+			report = append(report, BudgetResult{
+				Path:    virtualPath,
+				Pattern: budget.Pattern,
+				Size:    size,
+				MaxSize: budget.MaxSize,
+				Gzipped: budget.Gzipped,
+			})
+		}
+	}
+	return report, nil
+}
+
+// enforceBudgets checks the budgets after a build, failing it or logging a
+// warning per file over budget.
+func (f *Assets) enforceBudgets() error {
+	if len(f.Budgets) == 0 {
+		return nil
+	}
+	report, err := f.CheckBudgets()
+	if err != nil {
+		return err
+	}
+	exceeded := report.Exceeded()
+	if len(exceeded) == 0 {
+		return nil
+	}
+	if f.EnforceBudgets {
+		return &BudgetError{Report: exceeded}
+	}
+	for _, result := range exceeded {
+		f.logWarn("size budget exceeded",
+			logkit.String("path", result.Path),
+			logkit.Int("size", result.Size),
+			logkit.Int("budget", result.MaxSize))
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// BuildAll loads, preprocesses, compresses and hashes every registered file
+// using a pool of BuildWorkers goroutines (runtime.NumCPU() if unset), so no
+// request pays the cost of lazy loading, and reports how long each step took.
+// It returns the first error encountered. The Budgets are checked once
+// everything is built; if they fail, the report is returned with the error.
+func (f *Assets) BuildAll(ctx context.Context) (*BuildReport, error) {
+	started := time.Now()
+	if err := f.loadAll(ctx, f.Paths()); err != nil {
+		return nil, err
+	}
+
+	report := &BuildReport{Duration: time.Since(started)}
+	f.lock.RLock()
+	for _, entry := range f.entries {
+		if entry.report != nil {
+			report.Assets = append(report.Assets, *entry.report)
+		}
+	}
+	f.lock.RUnlock()
+	sort.Slice(report.Assets, func(i, j int) bool { return report.Assets[i].VirtualPath < report.Assets[j].VirtualPath })
+	return report, f.enforceBudgets()
+}
+
+// loadAll loads paths with a pool of BuildWorkers goroutines, starting them
+// in order, and returns the first error encountered.
+func (f *Assets) loadAll(ctx context.Context, paths []string) error {
+	return f.parallel(ctx, paths, func(virtualPath string) error {
+		_, err := f.get(ctx, virtualPath)
+		return err
+	})
+}
+
+// parallel calls fn for paths with a pool of BuildWorkers goroutines,
+// starting in order, and returns the first error encountered.
+func (f *Assets) parallel(ctx context.Context, paths []string, fn func(virtualPath string) error) error {
+	workers := f.BuildWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errLock sync.Mutex
+	setErr := func(err error) {
+		errLock.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errLock.Unlock()
+		cancel()
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for virtualPath := range queue {
+				if err := fn(virtualPath); err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, virtualPath := range paths {
+		select {
+		case queue <- virtualPath:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// BuildReport tells how long building the assets took, and where the time
+// went.
+type BuildReport struct {
+	Duration time.Duration // wall time of BuildAll
+	Assets   []AssetReport // sorted by virtual path
+}
+
+// AssetReport tells how an asset was built. Files loaded before BuildAll
+// report their first load.
+type AssetReport struct {
+	VirtualPath   string
+	Hash          string
+	SourceSize    int // before preprocessing
+	Size          int
+	GzippedSize   int // 0 if not compressed up front
+	Read          time.Duration
+	Preprocessors []PreprocessorTiming
+	Hashing       time.Duration
+	Compress      time.Duration
+}
+
+// PreprocessorTiming is the time a preprocessor took on one file.
+type PreprocessorTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Total is the time spent building the asset.
+func (r AssetReport) Total() time.Duration {
+	total := r.Read + r.Hashing + r.Compress
+	for _, preprocessor := range r.Preprocessors {
+		total += preprocessor.Duration
+	}
+	return total
+}
+
+// String formats the report as tables of the preprocessors by total time,
+// and of the assets, slowest first.
+func (r *BuildReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "built %v assets in %v\n\n", len(r.Assets), r.Duration)
+
+	totals := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	for _, asset := range r.Assets {
+		for _, preprocessor := range asset.Preprocessors {
+			totals[preprocessor.Name] += preprocessor.Duration
+			counts[preprocessor.Name]++
+		}
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return totals[names[i]] > totals[names[j]] })
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "preprocessor\tfiles\ttime\t")
+	for _, name := range names {
+		fmt.Fprintf(w, "%v\t%v\t%v\t\n", name, counts[name], totals[name].Round(time.Microsecond))
+	}
+	w.Flush()
+	buf.WriteString("\n")
+
+	assets := append([]AssetReport(nil), r.Assets...)
+	sort.SliceStable(assets, func(i, j int) bool { return assets[i].Total() > assets[j].Total() })
+	w = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "asset\tsource\tsize\tgzipped\tread\tpreprocess\thash\tcompress\ttotal\t")
+	for _, asset := range assets {
+		preprocess := asset.Total() - asset.Read - asset.Hashing - asset.Compress
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n", asset.VirtualPath, asset.SourceSize, asset.Size, asset.GzippedSize,
+			asset.Read.Round(time.Microsecond), preprocess.Round(time.Microsecond), asset.Hashing.Round(time.Microsecond),
+			asset.Compress.Round(time.Microsecond), asset.Total().Round(time.Microsecond))
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// BuildID returns a deterministic fingerprint of the whole asset set, which
+// changes whenever any file or virtual path changes. It is useful as a
+// deployment identifier or service worker cache version.
+func (f *Assets) BuildID() (string, error) {
+	h := f.HashFunc()
+	err := f.Walk(func(virtualPath string, file *File) error {
+		io.WriteString(h, virtualPath)
+		io.WriteString(h, "\x00")
+		io.WriteString(h, file.HashString)
+		io.WriteString(h, "\n")
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// Bundle registers virtualPath as the concatenation of the processed
+// members, in order. The bundle runs through the preprocessors of its own
+// extension (url rewriting, minification) and is served as one fingerprinted
+// asset. Re-adding a member rebuilds the bundle. Include it with the bundle
+// template func. A bundle including itself, directly or through other
+// bundles, fails to load.
+func (f *Assets) Bundle(virtualPath string, members ...string) {
+	members = append([]string(nil), members...)
+	separator := []byte("\n")
+	if ext := path.Ext(virtualPath); ext == ".js" || ext == ".mjs" {
+		separator = []byte(";\n")
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.aliases, virtualPath)
+	delete(f.chunks, virtualPath)
+	f.entries[virtualPath] = &assetEntry{
+		virtualPath: virtualPath,
+		generate: func() ([]byte, error) {
+			if cycle := f.bundleCycle(virtualPath); cycle != nil {
+				return nil, fmt.Errorf("%v: bundle includes itself: %v", virtualPath, strings.Join(cycle, " -> "))
+			}
+
+			var buf bytes.Buffer
+			for i, member := range members {
+				file, err := f.Get(member)
+				if err != nil {
+					return nil, err
+				}
+				if i > 0 {
+					buf.Write(separator)
+				}
+				buf.Write(file.Content)
+			}
+			return buf.Bytes(), nil
+		},
+	}
+	f.bundles[virtualPath] = members
+	f.invalidateDependents(virtualPath, make(map[string]bool))
+	atomic.AddInt64(&f.version, 1)
+}
+
+// bundleCycle returns the chain of bundles through which virtualPath
+// includes itself, or nil if it doesn't. Loading such a bundle would wait on
+// itself forever.
+func (f *Assets) bundleCycle(virtualPath string) []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	visited := make(map[string]bool)
+	var visit func(chain []string) []string
+	visit = func(chain []string) []string {
+		for _, member := range f.bundles[chain[len(chain)-1]] {
+			if target, found := f.aliases[member]; found {
+				member = target
+			}
+			next := append(chain[:len(chain):len(chain)], member)
+			if member == virtualPath {
+				return next
+			}
+			if !visited[member] {
+				visited[member] = true
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+	return visit([]string{virtualPath})
+}
+
+// BundleTag returns the <script> or <link rel="stylesheet"> tag for the
+// bundle at virtualPath. In ModeDevelopment the members are included one by
+// one instead, which keeps them apart in browser devtools.
+func (f *Assets) BundleTag(virtualPath string) (template.HTML, error) {
+	f.lock.RLock()
+	members, found := f.bundles[virtualPath]
+	f.lock.RUnlock()
+	if !found || f.currentMode() != ModeDevelopment {
+		members = []string{virtualPath}
+	}
+
+	tag := f.StylesheetTag
+	if ext := path.Ext(virtualPath); ext == ".js" || ext == ".mjs" {
+		tag = f.ScriptTag
+	}
+
+	var buf bytes.Buffer
+	for _, member := range members {
+		html, err := tag(member)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(string(html))
+	}
+	return template.HTML(buf.String()), nil
+}
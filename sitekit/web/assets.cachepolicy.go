@@ -0,0 +1,76 @@
+package web
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy describes the Cache-Control header sent with served assets.
+type CachePolicy struct {
+	// MaxAge is how long browsers may cache the asset.
+	MaxAge time.Duration
+
+	// Immutable tells browsers the content at the URL never changes, which
+	// holds for fingerprinted URLs.
+	Immutable bool
+
+	// SMaxAge, StaleWhileRevalidate and StaleIfError are directives for
+	// shared caches such as CDNs, independent from the browser MaxAge.
+	// Zero values are left out.
+	SMaxAge              time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+
+	// NoCache requires revalidation on every use, for unhashed dev serving.
+	NoCache bool
+
+	// Extensions overrides the policy for files with the given extension (".mp4").
+	Extensions map[string]CachePolicy
+}
+
+// DefaultCachePolicy returns the policy used by NewAssets: cache for a year.
+func DefaultCachePolicy() CachePolicy {
+	return CachePolicy{
+		MaxAge:    time.Second * 31556926,
+		Immutable: true,
+	}
+}
+
+// NoCachePolicy returns a policy that makes clients revalidate every time.
+func NoCachePolicy() CachePolicy {
+	return CachePolicy{NoCache: true}
+}
+
+func (p CachePolicy) forExtension(extension string) CachePolicy {
+	if override, found := p.Extensions[strings.ToLower(extension)]; found {
+		return override
+	}
+	return p
+}
+
+// CacheControl returns the Cache-Control header value for the policy.
+func (p CachePolicy) CacheControl() string {
+	if p.NoCache {
+		return "no-cache"
+	}
+
+	directives := []string{"public", "max-age=" + seconds(p.MaxAge)}
+	if p.SMaxAge > 0 {
+		directives = append(directives, "s-maxage="+seconds(p.SMaxAge))
+	}
+	if p.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+seconds(p.StaleWhileRevalidate))
+	}
+	if p.StaleIfError > 0 {
+		directives = append(directives, "stale-if-error="+seconds(p.StaleIfError))
+	}
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// TemplateErrors collects the errors found by CheckTemplates and Verify.
+type TemplateErrors []error
+
+func (e TemplateErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// CheckTemplates parses every template set up front, and checks that the
+// templates they call are defined, so broken templates fail a deploy rather
+// than the first request. With no sets, the sets defined with DefineTemplate
+// are checked, and every .tmpl and .html file on its own, with the partials.
+// All errors are returned together as TemplateErrors.
+func (f *Assets) CheckTemplates(sets [][]string) error {
+	if sets == nil {
+		f.lock.RLock()
+		names := make([]string, 0, len(f.templateSets))
+		for name := range f.templateSets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sets = append(sets, f.templateSets[name])
+		}
+		f.lock.RUnlock()
+		for _, virtualPath := range f.Paths() {
+			if extension := path.Ext(virtualPath); extension == ".tmpl" || extension == ".html" {
+				sets = append(sets, []string{virtualPath})
+			}
+		}
+	}
+
+	var errs TemplateErrors
+	for _, set := range sets {
+		t, err := f.GetTemplate(set)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, defined := range t.Templates() {
+			if defined.Tree == nil {
+				continue
+			}
+			err := walkParseTree(defined.Tree.Root, func(node parse.Node) error {
+				if call, ok := node.(*parse.TemplateNode); ok && t.Lookup(call.Name) == nil {
+					return fmt.Errorf("%v: template %q calls undefined template %q", strings.Join(set, ", "), defined.Name(), call.Name)
+				}
+				return nil
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionConfig controls which files get pre-compressed copies and how.
+type CompressionConfig struct {
+	// Level is a compress/gzip level. 0 means gzip.DefaultCompression.
+	Level int
+
+	// MinSize is the smallest content size (in bytes) worth compressing.
+	MinSize int
+
+	// ExcludedExtensions lists extensions (".jpg") of files that are never
+	// compressed, typically formats that are already compressed.
+	ExcludedExtensions []string
+
+	// MinSavingsPercent drops a compressed copy unless it is at least this
+	// many percent smaller than the original, in which case the content is
+	// served with identity encoding instead.
+	MinSavingsPercent int
+
+	// Zstd enables an additional zstd compressed copy of each file.
+	Zstd bool
+
+	// StreamThreshold is the content size (in bytes) from which files are
+	// compressed on the fly while serving rather than keeping a compressed
+	// copy in memory. 0 disables streaming.
+	StreamThreshold int
+
+	// StreamCacheSize is the total size (in bytes) of the LRU cache holding
+	// recently streamed compressed results.
+	StreamCacheSize int
+}
+
+// DefaultCompressionConfig returns the configuration used by NewAssets.
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Level:             gzip.DefaultCompression,
+		MinSize:           0,
+		MinSavingsPercent: 10,
+		StreamThreshold:   0,
+		StreamCacheSize:   32 << 20,
+		ExcludedExtensions: []string{
+			".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".ico",
+			".woff", ".woff2",
+			".gz", ".br", ".zst", ".zip",
+			".mp3", ".mp4", ".ogg", ".webm",
+		},
+	}
+}
+
+func (c *CompressionConfig) shouldCompress(extension string, content []byte) bool {
+	if len(content) < c.MinSize {
+		return false
+	}
+	for _, excluded := range c.ExcludedExtensions {
+		if strings.EqualFold(excluded, extension) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CompressionConfig) shouldStream(content []byte) bool {
+	return c.StreamThreshold > 0 && len(content) >= c.StreamThreshold
+}
+
+// streamEncodings returns the encodings available for streamed files, in
+// order of preference.
+func (c *CompressionConfig) streamEncodings() []string {
+	if c.Zstd {
+		return []string{"zstd", "gzip"}
+	}
+	return []string{"gzip"}
+}
+
+func (c *CompressionConfig) gzip(content []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buffer bytes.Buffer
+	compressor, err := gzip.NewWriterLevel(&buffer, level)
+	if err != nil {
+		return nil, err
+	}
+	compressor.Write(content)
+	compressor.Close()
+	return c.worthwhile(content, buffer.Bytes()), nil
+}
+
+func (c *CompressionConfig) zstd(content []byte) []byte {
+	return c.worthwhile(content, zstdEncoder().EncodeAll(content, nil))
+}
+
+// worthwhile returns compressed if it saves at least MinSavingsPercent over
+// content, and nil otherwise.
+func (c *CompressionConfig) worthwhile(content []byte, compressed []byte) []byte {
+	if len(compressed)*100 > len(content)*(100-c.MinSavingsPercent) {
+		return nil
+	}
+	return compressed
+}
+
+// writeStreamed compresses the file content directly into w, keeping the
+// result in the stream cache for the next request.
+func (f *Assets) writeStreamed(w http.ResponseWriter, file *File, encoding string) error {
+	if compressed := f.streamCache.get(streamCacheKey(file, encoding)); compressed != nil {
+		w.Header().Set("Content-Length", strconv.Itoa(len(compressed)))
+		_, err := w.Write(compressed)
+		return err
+	}
+	return f.compressStreamed(w, file, encoding)
+}
+
+// streamedLength returns the length of the file content compressed with
+// encoding, compressing it if it isn't in the stream cache.
+func (f *Assets) streamedLength(file *File, encoding string) (int, error) {
+	if compressed := f.streamCache.get(streamCacheKey(file, encoding)); compressed != nil {
+		return len(compressed), nil
+	}
+	var length byteCounter
+	err := f.compressStreamed(&length, file, encoding)
+	return int(length), err
+}
+
+// compressStreamed writes the file content compressed with encoding to w,
+// keeping the result in the stream cache if it fits.
+func (f *Assets) compressStreamed(w io.Writer, file *File, encoding string) error {
+	key := streamCacheKey(file, encoding)
+
+	var buffer bytes.Buffer
+	var out io.Writer = w
+	cacheable := len(file.Content) <= f.Compression.StreamCacheSize
+	if cacheable {
+		out = io.MultiWriter(w, &buffer)
+	}
+
+	var compressor io.WriteCloser
+	var err error
+	switch encoding {
+	case "zstd":
+		compressor, err = zstd.NewWriter(out)
+	default:
+		level := f.Compression.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		compressor, err = gzip.NewWriterLevel(out, level)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := compressor.Write(file.Content); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+
+	if cacheable {
+		f.streamCache.add(key, buffer.Bytes(), f.Compression.StreamCacheSize)
+	}
+	return nil
+}
+
+func streamCacheKey(file *File, encoding string) string {
+	return file.HashString + ";" + encoding
+}
+
+// compressedCache is a small LRU cache of compressed content, bounded by the
+// total number of bytes held.
+type compressedCache struct {
+	lock    sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type compressedCacheEntry struct {
+	key     string
+	content []byte
+}
+
+func newCompressedCache() *compressedCache {
+	return &compressedCache{
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *compressedCache) get(key string) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element := c.entries[key]
+	if element == nil {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*compressedCacheEntry).content
+}
+
+func (c *compressedCache) add(key string, content []byte, maxSize int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.entries[key] != nil || len(content) > maxSize {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&compressedCacheEntry{key: key, content: content})
+	c.size += len(content)
+
+	for c.size > maxSize {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*compressedCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= len(entry.content)
+	}
+}
+
+// encodings returns the content encodings worth serving for the file, in
+// order of preference. Missing variants and variants that aren't smaller than
+// the identity content are left out.
+func (f *File) encodings() []string {
+	encodings := make([]string, 0, 3)
+	for _, encoding := range []string{"br", "zstd", "gzip"} {
+		if content := f.encoded(encoding); content != nil && len(content) < len(f.Content) {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// encoded returns the content in the given encoding ("" for identity).
+func (f *File) encoded(encoding string) []byte {
+	switch encoding {
+	case "br":
+		return f.ContentBrotli
+	case "zstd":
+		return f.ContentZstd
+	case "gzip":
+		return f.ContentGZipped
+	}
+	return f.Content
+}
+
+// negotiateEncoding picks the encoding from available (ordered by preference)
+// with the highest quality value in the Accept-Encoding header. Ties go to the
+// earlier encoding. "" (identity) is returned when no stored encoding is
+// acceptable or identity is explicitly preferred.
+func negotiateEncoding(acceptEncoding string, available []string) string {
+	qualities := parseQualities(acceptEncoding)
+
+	quality := func(encoding string) float64 {
+		if q, found := qualities[encoding]; found {
+			return q
+		}
+		if q, found := qualities["*"]; found {
+			return q
+		}
+		return 0
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range available {
+		if q := quality(encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	// identity is acceptable unless explicitly refused.
+	identityQ := 1.0
+	if q, found := qualities["identity"]; found {
+		identityQ = q
+	} else if q, found := qualities["*"]; found && q == 0 {
+		identityQ = 0
+	}
+	if best != "" && identityQ > bestQ {
+		return ""
+	}
+	return best
+}
+
+// readSidecar returns the content of the precompressed sidecar file of
+// source with extension, or nil if it doesn't exist or is older than source,
+// and so may have been made from different content.
+func readSidecar(source string, extension string) ([]byte, error) {
+	info, err := os.Stat(source + extension)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Before(sourceInfo.ModTime()) {
+		return nil, nil
+	}
+	return ioutil.ReadFile(source + extension)
+}
+
+var zstdOnce sync.Once
+var zstdShared *zstd.Encoder
+
+// zstdEncoder returns a shared encoder. EncodeAll is safe for concurrent use.
+func zstdEncoder() *zstd.Encoder {
+	zstdOnce.Do(func() {
+		zstdShared, _ = zstd.NewWriter(nil)
+	})
+	return zstdShared
+}
+
+// byteCounter is an io.Writer counting the bytes written to it.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// parseQualities returns the lowercased values of an Accept style header
+// with their quality values, 1 unless given with q=.
+func parseQualities(header string) map[string]float64 {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i != -1 {
+			name = part[:i]
+			for _, param := range strings.Split(part[i+1:], ";") {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					value, err := strconv.ParseFloat(param[2:], 64)
+					if err != nil {
+						value = 0
+					}
+					q = value
+				}
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			qualities[name] = q
+		}
+	}
+	return qualities
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+	"context"
+	"strings"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// ContextPreprocessor is a preprocessor that is told more about the file it
+// processes than a Preprocessor.
+type ContextPreprocessor func(ctx *PreprocessContext, content []byte) ([]byte, error)
+
+// PreprocessContext describes the file being processed.
+type PreprocessContext struct {
+	Context     context.Context // carries the logkit operation, if any, and the pprof labels of the load
+	Assets      *Assets
+	Path        string // virtual path
+	SourcePath  string // path on disk, empty for generated content
+	ContentType string
+	Mode        Mode
+}
+
+// Debug logs msg with logkit, tagged with the path of the file.
+func (c *PreprocessContext) Debug(msg string, fields ...logkit.Field) {
+	logkit.Debug(c.Context, msg, append(fields, logkit.String("path", c.Path))...)
+}
+
+// Info logs msg with logkit, tagged with the path of the file.
+func (c *PreprocessContext) Info(msg string, fields ...logkit.Field) {
+	logkit.Info(c.Context, msg, append(fields, logkit.String("path", c.Path))...)
+}
+
+// Warn logs msg with logkit, tagged with the path of the file.
+func (c *PreprocessContext) Warn(msg string, fields ...logkit.Field) {
+	logkit.Warn(c.Context, msg, append(fields, logkit.String("path", c.Path))...)
+}
+
+// AddDerived registers content derived from the file, such as a source map
+// or an image variant, and returns its virtual path. A virtualPath not
+// starting with "/" is a suffix to the path of the file (".map").
+func (c *PreprocessContext) AddDerived(virtualPath string, content []byte, options FileOptions) string {
+	if !strings.HasPrefix(virtualPath, "/") {
+		virtualPath = c.Path + virtualPath
+	}
+	c.Assets.AddContent(virtualPath, content, options)
+	return virtualPath
+}
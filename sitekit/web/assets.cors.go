@@ -0,0 +1,70 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig allows cross-origin requests for assets whose virtual path
+// starts with Prefix, e.g. web fonts served from a separate asset domain.
+type CORSConfig struct {
+	Prefix           string
+	AllowedOrigins   []string // "*" allows any origin
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func (c *CORSConfig) allows(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// reflects reports whether responses name the request origin, rather than
+// allowing any origin with "*", and so differ by origin.
+func (c *CORSConfig) reflects() bool {
+	return c.AllowCredentials || len(c.AllowedOrigins) != 1 || c.AllowedOrigins[0] != "*"
+}
+
+// writeCORS adds the CORS response headers for file, and reports whether the
+// request was a preflight that has now been answered. Responses for files
+// under a prefix vary by origin even without an Origin header, so shared
+// caches don't hand a response without CORS headers to cross-origin requests.
+func (f *Assets) writeCORS(w http.ResponseWriter, r *http.Request, file *File) bool {
+	origin := r.Header.Get("Origin")
+	for i := range f.CORS {
+		config := &f.CORS[i]
+		if !strings.HasPrefix(file.virtualPath, config.Prefix) {
+			continue
+		}
+
+		if !config.reflects() {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || !config.allows(origin) {
+				return false
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if origin != "" && r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+			if config.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.FormatInt(int64(config.MaxAge/time.Second), 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return true
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	criticalPlaceholderRegexp = regexp.MustCompile(`<!--inlinecritical ([^>]*)-->`)
+	htmlTagRegexp             = regexp.MustCompile(`<([a-zA-Z][\w-]*)([^>]*)>`)
+	htmlIDRegexp              = regexp.MustCompile(`(?i)\sid\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	htmlClassRegexp           = regexp.MustCompile(`(?i)\sclass\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	cssSelectorPartRegexp     = regexp.MustCompile(`([#.]?)(-?[_a-zA-Z][\w-]*|\*)`)
+	cssIgnoredSelectorRegexp  = regexp.MustCompile(`::?[\w-]+(\([^)]*\))?|\[[^\]]*\]`)
+)
+
+// CriticalCSS returns the rules of the stylesheets that apply to elements in
+// html, with the @font-face and conditional rules they need. Pass the part of
+// a page above the fold for just the rules needed to show it. Selectors are
+// matched on tag names, ids and classes only, so the result may contain a few
+// rules too many but none too few.
+func (f *Assets) CriticalCSS(html []byte, stylesheets ...string) (string, error) {
+	document := scanHTML(html)
+	var buf bytes.Buffer
+	for _, stylesheet := range stylesheets {
+		file, err := f.Get(stylesheet)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(criticalRules(file.Content, document))
+	}
+	return buf.String(), nil
+}
+
+// inlineCritical replaces the placeholders left by the inlinecritical template
+// func in a rendered page with the critical rules of the stylesheet and a
+// deferred link to the full stylesheet.
+func (f *Assets) inlineCritical(page []byte) ([]byte, error) {
+	var err error
+	result := criticalPlaceholderRegexp.ReplaceAllFunc(page, func(placeholder []byte) []byte {
+		if err != nil {
+			return placeholder
+		}
+		stylesheet := string(criticalPlaceholderRegexp.FindSubmatch(placeholder)[1])
+
+		var critical, url string
+		if critical, err = f.CriticalCSS(page, stylesheet); err != nil {
+			return placeholder
+		}
+		if url, err = f.GetUrl(stylesheet); err != nil {
+			return placeholder
+		}
+		href := template.HTMLEscapeString(url)
+		return []byte("<style>" + critical + "</style>" +
+			"<link rel=\"preload\" href=\"" + href + "\" as=\"style\" onload=\"this.onload=null;this.rel='stylesheet'\">" +
+			"<noscript><link rel=\"stylesheet\" href=\"" + href + "\"></noscript>")
+	})
+	return result, err
+}
+
+func inlineCriticalPlaceholder(virtualPath string) (template.HTML, error) {
+	if virtualPath[0] != '/' {
+		return "", errors.New("path argument must start with '/'")
+	}
+	if strings.ContainsAny(virtualPath, "<>") {
+		return "", errors.New("invalid path: " + virtualPath)
+	}
+	return template.HTML("<!--inlinecritical " + virtualPath + "-->"), nil
+}
+
+// htmlDocument holds the tag names, ids and classes used in a page.
+type htmlDocument struct {
+	tags, ids, classes map[string]bool
+}
+
+func scanHTML(html []byte) htmlDocument {
+	document := htmlDocument{
+		tags:    map[string]bool{"html": true, "body": true},
+		ids:     make(map[string]bool),
+		classes: make(map[string]bool),
+	}
+	for _, tag := range htmlTagRegexp.FindAllSubmatch(html, -1) {
+		document.tags[strings.ToLower(string(tag[1]))] = true
+		if match := htmlIDRegexp.FindSubmatch(tag[2]); match != nil {
+			document.ids[string(match[1])+string(match[2])+string(match[3])] = true
+		}
+		if match := htmlClassRegexp.FindSubmatch(tag[2]); match != nil {
+			for _, class := range strings.Fields(string(match[1]) + string(match[2]) + string(match[3])) {
+				document.classes[class] = true
+			}
+		}
+	}
+	return document
+}
+
+// matches reports whether every compound of any of the selectors could
+// match an element of the document.
+func (d htmlDocument) matches(selectors string) bool {
+	for _, selector := range splitTopLevel(selectors, ',') {
+		selector = cssIgnoredSelectorRegexp.ReplaceAllString(selector, " ")
+		selector = strings.NewReplacer(">", " ", "+", " ", "~", " ").Replace(selector)
+		matched := true
+		for _, compound := range strings.Fields(selector) {
+			if !d.matchesCompound(compound) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (d htmlDocument) matchesCompound(compound string) bool {
+	for _, part := range cssSelectorPartRegexp.FindAllStringSubmatch(compound, -1) {
+		switch {
+		case part[2] == "*":
+		case part[1] == "#" && !d.ids[part[2]]:
+			return false
+		case part[1] == "." && !d.classes[part[2]]:
+			return false
+		case part[1] == "" && !d.tags[strings.ToLower(part[2])]:
+			return false
+		}
+	}
+	return true
+}
+
+// criticalRules returns the rules of css used by document.
+func criticalRules(css []byte, document htmlDocument) []byte {
+	var buf bytes.Buffer
+	for _, rule := range parseCSSRules(css) {
+		switch {
+		case rule.body == nil:
+			// statements like @charset and @import
+		case strings.HasPrefix(rule.prelude, "@font-face"):
+			buf.WriteString(rule.prelude + "{" + string(rule.body) + "}")
+		case strings.HasPrefix(rule.prelude, "@media"), strings.HasPrefix(rule.prelude, "@supports"),
+			strings.HasPrefix(rule.prelude, "@layer"), strings.HasPrefix(rule.prelude, "@container"):
+			if nested := criticalRules(rule.body, document); len(nested) > 0 {
+				buf.WriteString(rule.prelude + "{" + string(nested) + "}")
+			}
+		case strings.HasPrefix(rule.prelude, "@"):
+			// @keyframes, @page and friends are not needed for the first paint
+		case document.matches(rule.prelude):
+			buf.WriteString(rule.prelude + "{" + string(rule.body) + "}")
+		}
+	}
+	return buf.Bytes()
+}
+
+type cssRule struct {
+	prelude string
+	body    []byte // nil for statements without a block
+}
+
+// parseCSSRules splits css into its top level rules, skipping comments.
+func parseCSSRules(css []byte) []cssRule {
+	var rules []cssRule
+	start, depth, bodyStart := 0, 0, 0
+	var prelude string
+	for i := 0; i < len(css); i++ {
+		switch c := css[i]; c {
+		case '/':
+			if i+1 < len(css) && css[i+1] == '*' {
+				end := bytes.Index(css[i+2:], []byte("*/"))
+				if end == -1 {
+					return rules
+				}
+				if depth == 0 {
+					// keep comments out of preludes
+					css = append(css[:i:i], css[i+2+end+2:]...)
+					i--
+				} else {
+					i += 2 + end + 1
+				}
+			}
+		case '"', '\'':
+			for i++; i < len(css) && css[i] != c; i++ {
+				if css[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			if depth == 0 {
+				prelude = strings.TrimSpace(string(css[start:i]))
+				bodyStart = i + 1
+			}
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				rules = append(rules, cssRule{prelude: prelude, body: append([]byte{}, bytes.TrimSpace(css[bodyStart:i])...)})
+				start = i + 1
+			}
+		case ';':
+			if depth == 0 {
+				if statement := strings.TrimSpace(string(css[start:i])); statement != "" {
+					rules = append(rules, cssRule{prelude: statement})
+				}
+				start = i + 1
+			}
+		}
+	}
+	return rules
+}
+
+// splitTopLevel splits s at sep outside of parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
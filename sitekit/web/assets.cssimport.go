@@ -0,0 +1,99 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var cssImportRegexp = regexp.MustCompile(`(?m)^[ \t]*@import\s+(?:url\(\s*["']?([^"')]+?)["']?\s*\)|["']([^"']+)["'])\s*([^;]*);[ \t]*\n?`)
+var cssCharsetRegexp = regexp.MustCompile(`(?m)^\s*@charset\s+[^;]+;\s*`)
+
+// CSSImportPreprocessor inlines @import statements of registered stylesheets,
+// giving one flattened stylesheet per entry point. Imports with a media
+// query are wrapped in @media. The url()s of imported files are resolved
+// relative to those files. Imports of external or unregistered files, and
+// imports with layer() or supports() conditions, are kept and moved to the
+// top, where css requires them.
+func CSSImportPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
+	var kept [][]byte
+	var imported []string
+	flattened, err := assets.flattenCSS(path, content, map[string]bool{path: true}, &kept, &imported)
+	assets.setSources(path, imported)
+	if err != nil {
+		return nil, err
+	}
+	if len(kept) == 0 {
+		return flattened, nil
+	}
+
+	// @charset has to stay first
+	var buf bytes.Buffer
+	if charset := cssCharsetRegexp.Find(flattened); charset != nil && bytes.HasPrefix(bytes.TrimSpace(flattened), []byte("@charset")) {
+		buf.Write(charset)
+		flattened = flattened[len(charset):]
+	}
+	for _, statement := range kept {
+		buf.Write(statement)
+	}
+	buf.Write(flattened)
+	return buf.Bytes(), nil
+}
+
+func (f *Assets) flattenCSS(virtualPath string, content []byte, importing map[string]bool, kept *[][]byte, imported *[]string) ([]byte, error) {
+	var err error
+	result := cssImportRegexp.ReplaceAllFunc(content, func(statement []byte) []byte {
+		if err != nil {
+			return statement
+		}
+		match := cssImportRegexp.FindSubmatch(statement)
+		reference := string(match[1]) + string(match[2])
+		condition := strings.TrimSpace(string(match[3]))
+
+		rootedPath := ""
+		if isLocalReference(reference, true) && !strings.HasPrefix(condition, "layer") && !strings.HasPrefix(condition, "supports") {
+			rootedPath, _ = f.getRooted(virtualPath, reference)
+			f.lock.RLock()
+			if f.lookup(rootedPath) == nil {
+				rootedPath = ""
+			}
+			f.lock.RUnlock()
+		}
+		if rootedPath == "" {
+			statement = bytes.TrimSpace(statement)
+			*kept = append(*kept, append(statement, '\n'))
+			return nil
+		}
+
+		if importing[rootedPath] {
+			err = fmt.Errorf("%v: import cycle through %v", virtualPath, rootedPath)
+			return statement
+		}
+		*imported = append(*imported, rootedPath)
+
+		source, readErr := f.readSource(rootedPath)
+		if readErr != nil {
+			err = readErr
+			return statement
+		}
+		source = cssCharsetRegexp.ReplaceAll(source, nil)
+		if source, err = AssetCssPreprocessor(f, rootedPath, source); err != nil {
+			return statement
+		}
+
+		importing[rootedPath] = true
+		source, err = f.flattenCSS(rootedPath, source, importing, kept, imported)
+		delete(importing, rootedPath)
+		if err != nil {
+			return statement
+		}
+
+		source = append(bytes.TrimRight(source, "\n"), '\n')
+		if condition != "" {
+			return []byte("@media " + condition + " {\n" + string(source) + "}\n")
+		}
+		return source
+	})
+	return result, err
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AssetInfo describes a registered asset, for troubleshooting.
+type AssetInfo struct {
+	VirtualPath   string    `json:"virtualPath"`
+	Path          string    `json:"path,omitempty"`    // on disk, empty for generated content
+	AliasOf       string    `json:"aliasOf,omitempty"` // the target of an alias
+	Loaded        bool      `json:"loaded"`            // processed and cached
+	LoadedAt      time.Time `json:"loadedAt,omitempty"`
+	Hash          string    `json:"hash,omitempty"`
+	ContentType   string    `json:"contentType,omitempty"`
+	Size          int       `json:"size"`
+	GzippedSize   int       `json:"gzippedSize"` // 0 if not compressed yet
+	Preprocessors []string  `json:"preprocessors"`
+}
+
+// AssetInfos describes every registered asset, sorted by virtual path. Only
+// loaded assets have a hash and sizes.
+func (f *Assets) AssetInfos() []AssetInfo {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	infos := make([]AssetInfo, 0, len(f.entries)+len(f.aliases))
+	for virtualPath, entry := range f.entries {
+		infos = append(infos, f.assetInfo(virtualPath, entry))
+	}
+	for virtualPath, target := range f.aliases {
+		if entry := f.entries[target]; entry != nil {
+			info := f.assetInfo(virtualPath, entry)
+			info.AliasOf = target
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].VirtualPath < infos[j].VirtualPath })
+	return infos
+}
+
+// assetInfo describes entry. The caller must hold the lock.
+func (f *Assets) assetInfo(virtualPath string, entry *assetEntry) AssetInfo {
+	info := AssetInfo{VirtualPath: virtualPath, Path: entry.path}
+	extension := path.Ext(entry.virtualPath)
+	if entry.path != "" {
+		extension = filepath.Ext(entry.path)
+	}
+	for _, rule := range f.preprocessorsFor(entry.virtualPath, extension) {
+		info.Preprocessors = append(info.Preprocessors, rule.name())
+	}
+	if file := entry.file; file != nil {
+		info.Loaded = true
+		info.LoadedAt = entry.loaded
+		info.Hash = file.HashString
+		info.ContentType = file.ContentType
+		info.Size = len(file.Content)
+		info.GzippedSize = len(file.ContentGZipped)
+	}
+	return info
+}
+
+// name names the processor of the rule for humans.
+func (r *PreprocessorRule) name() string {
+	if r.ContextProcessor != nil {
+		return funcName(reflect.ValueOf(r.ContextProcessor))
+	}
+	return funcName(reflect.ValueOf(r.Processor))
+}
+
+// funcName names a func for humans: "web.ScssPreprocessor" rather than
+// "github.com/oliverkofoed/gokit/sitekit/web.ScssPreprocessor.func1".
+func funcName(fn reflect.Value) string {
+	name := runtime.FuncForPC(fn.Pointer()).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	for strings.Contains(name, ".func") {
+		name = name[:strings.LastIndex(name, ".func")]
+	}
+	return name
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Assets</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{padding:.2em .6em;text-align:left;vertical-align:top}tr:nth-child(even){background:#f6f6f6}.unloaded{color:#999}</style>
+</head><body>
+<h1>{{len .}} assets</h1>
+<table>
+<tr><th>Virtual path</th><th>Path</th><th>Hash</th><th>Content type</th><th>Size</th><th>Gzipped</th><th>Preprocessors</th></tr>
+{{range .}}<tr{{if not .Loaded}} class="unloaded"{{end}}><td>{{.VirtualPath}}</td><td>{{if .AliasOf}}alias of {{.AliasOf}}{{else}}{{.Path}}{{end}}</td><td>{{if .Loaded}}{{.Hash}}{{else}}not loaded{{end}}</td><td>{{.ContentType}}</td><td>{{if .Loaded}}{{.Size}}{{end}}</td><td>{{if .GzippedSize}}{{.GzippedSize}}{{end}}</td><td>{{range .Preprocessors}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// DebugHandler serves a page listing the registered assets, or JSON with
+// ?format=json, to troubleshoot asset issues in production. It isn't served
+// by default; mount it somewhere private, e.g. behind an admin check.
+func (f *Assets) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		infos := f.AssetInfos()
+		w.Header().Set("Cache-Control", "no-store")
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(infos)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		debugTemplate.Execute(w, infos)
+	})
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+)
+
+// ManifestDiff lists the assets that differ between two manifests, each
+// sorted by virtual path.
+type ManifestDiff struct {
+	Added   []ManifestChange
+	Removed []ManifestChange
+	Changed []ManifestChange
+}
+
+// ManifestChange is an asset in either or both manifests. Old is empty for
+// added assets and New is empty for removed assets.
+type ManifestChange struct {
+	VirtualPath string
+	Old         ManifestEntry
+	New         ManifestEntry
+}
+
+// SizeDelta returns how many bytes the asset grew.
+func (c ManifestChange) SizeDelta() int {
+	return c.New.Size - c.Old.Size
+}
+
+// DiffManifests compares two manifests, e.g. of the base and head of a pull
+// request. An asset changed if its hash did.
+func DiffManifests(old, new Manifest) *ManifestDiff {
+	diff := &ManifestDiff{}
+	for virtualPath, entry := range new {
+		previous, found := old[virtualPath]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, ManifestChange{VirtualPath: virtualPath, New: entry})
+		case previous.Hash != entry.Hash:
+			diff.Changed = append(diff.Changed, ManifestChange{VirtualPath: virtualPath, Old: previous, New: entry})
+		}
+	}
+	for virtualPath, entry := range old {
+		if _, found := new[virtualPath]; !found {
+			diff.Removed = append(diff.Removed, ManifestChange{VirtualPath: virtualPath, Old: entry})
+		}
+	}
+	for _, changes := range [][]ManifestChange{diff.Added, diff.Removed, diff.Changed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].VirtualPath < changes[j].VirtualPath })
+	}
+	return diff
+}
+
+// Empty reports whether the manifests had the same assets.
+func (d *ManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// SizeDelta returns how many bytes all assets together grew.
+func (d *ManifestDiff) SizeDelta() int {
+	delta := 0
+	for _, changes := range [][]ManifestChange{d.Added, d.Removed, d.Changed} {
+		for _, change := range changes {
+			delta += change.SizeDelta()
+		}
+	}
+	return delta
+}
+
+func (d *ManifestDiff) String() string {
+	if d.Empty() {
+		return "no asset changes\n"
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tasset\told\tnew\tdelta")
+	d.each(func(status string, change ManifestChange) {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", status, change.VirtualPath, change.Old.Size, change.New.Size, signed(change.SizeDelta()))
+	})
+	fmt.Fprintf(w, "\ttotal\t\t\t%v\n", signed(d.SizeDelta()))
+	w.Flush()
+	return buf.String()
+}
+
+// Markdown formats the diff as a markdown table for posting on pull requests.
+func (d *ManifestDiff) Markdown() string {
+	if d.Empty() {
+		return "No asset changes.\n"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v added, %v removed, %v changed, %v bytes in total.\n\n", len(d.Added), len(d.Removed), len(d.Changed), signed(d.SizeDelta()))
+	buf.WriteString("| | Asset | Old | New | Delta |\n|---|---|---:|---:|---:|\n")
+	d.each(func(status string, change ManifestChange) {
+		fmt.Fprintf(&buf, "| %v | `%v` | %v | %v | %v |\n", status, change.VirtualPath, change.Old.Size, change.New.Size, signed(change.SizeDelta()))
+	})
+	return buf.String()
+}
+
+func (d *ManifestDiff) each(fn func(status string, change ManifestChange)) {
+	for _, change := range d.Added {
+		fn("+", change)
+	}
+	for _, change := range d.Removed {
+		fn("-", change)
+	}
+	for _, change := range d.Changed {
+		fn("~", change)
+	}
+}
+
+func signed(n int) string {
+	if n > 0 {
+		return fmt.Sprintf("+%v", n)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DiskCache keeps the results of expensive preprocessing steps (SCSS, PostCSS,
+// external commands, image transforms) on disk, keyed by the hash of their
+// input and the step, so they are skipped across restarts when nothing
+// changed. Bump Version when the tools or their configuration change.
+type DiskCache struct {
+	Dir     string
+	Version string
+}
+
+// NewDiskCache returns a cache storing its entries in dir.
+func NewDiskCache(dir string, version string) *DiskCache {
+	return &DiskCache{Dir: dir, Version: version}
+}
+
+func (c *DiskCache) path(step string, input []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Version))
+	h.Write([]byte{0})
+	h.Write([]byte(step))
+	h.Write([]byte{0})
+	h.Write(input)
+	key := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(c.Dir, key[:2], key[2:])
+}
+
+// get returns the cached output of step for input, or nil.
+func (c *DiskCache) get(step string, input []byte) []byte {
+	content, err := ioutil.ReadFile(c.path(step, input))
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+// put stores output, writing to a temporary file first so readers never see
+// a partial entry. Failures only cost a cache miss later.
+func (c *DiskCache) put(step string, input []byte, output []byte) {
+	target := c.path(step, input)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(target), ".tmp")
+	if err != nil {
+		return
+	}
+	_, err = temp.Write(output)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return
+	}
+	if err := os.Rename(temp.Name(), target); err != nil {
+		os.Remove(temp.Name())
+	}
+}
+
+// cached returns the output of step for input from Assets.Cache, running
+// produce and storing its result on a miss. step identifies the processing,
+// e.g. the command line of an external tool.
+func (f *Assets) cached(step string, input []byte, produce func() ([]byte, error)) ([]byte, error) {
+	if f.Cache == nil {
+		return produce()
+	}
+	if output := f.Cache.get(step, input); output != nil {
+		return output, nil
+	}
+
+	output, err := produce()
+	if err != nil {
+		return nil, err
+	}
+	f.Cache.put(step, input, output)
+	return output, nil
+}
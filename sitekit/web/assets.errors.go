@@ -0,0 +1,248 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// ErrorRenderer writes an error response. The request is nil when the error
+// happens outside of Serve, e.g. while rendering a template.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, code int, err error)
+
+var errAssetNotFound = errors.New("404 - File not found")
+
+// JSONErrorRenderer writes errors as {"status": code, "error": message}.
+func JSONErrorRenderer(w http.ResponseWriter, r *http.Request, code int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}{code, err.Error()})
+}
+
+// ErrorTemplateData is passed to templates rendered by TemplateErrorRenderer.
+type ErrorTemplateData struct {
+	Code  int
+	Error string
+}
+
+// TemplateErrorRenderer renders errors with the given template set, falling
+// back to plain text if the template itself fails.
+func TemplateErrorRenderer(assets *Assets, templatePathArr []string) ErrorRenderer {
+	return func(w http.ResponseWriter, r *http.Request, code int, err error) {
+		html, renderErr := assets.RenderTemplateString(templatePathArr, ErrorTemplateData{Code: code, Error: err.Error()})
+		if renderErr != nil {
+			httpError(w, code, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write([]byte(html))
+	}
+}
+
+func (f *Assets) notFound(w http.ResponseWriter, r *http.Request) {
+	if f.NotFoundHandler != nil {
+		f.NotFoundHandler.ServeHTTP(w, r)
+		return
+	}
+	f.renderError(w, r, http.StatusNotFound, errAssetNotFound)
+}
+
+func (f *Assets) renderError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	if code >= http.StatusInternalServerError {
+		fields := []logkit.Field{logkit.Int("status", code), logkit.Err(err)}
+		if r != nil {
+			fields = append(fields, logkit.String("url", r.URL.String()))
+		}
+		f.logError("serving failed", fields...)
+	}
+	if f.ErrorRenderer != nil {
+		f.ErrorRenderer(w, r, code, err)
+		return
+	}
+	if f.currentMode() == ModeDevelopment {
+		DevErrorRenderer(w, r, code, err)
+		return
+	}
+	httpError(w, code, err.Error())
+}
+
+// PreprocessError locates an error in the source of an asset or template.
+type PreprocessError struct {
+	Path    string // virtual path of the file
+	Line    int    // 1-based, 0 if unknown
+	Column  int    // 1-based, 0 if unknown
+	Snippet string // the source lines around Line
+	Err     error
+}
+
+func (e *PreprocessError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%v:%v:%v: %v", e.Path, e.Line, e.Column, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("%v:%v: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Path, e.Err)
+}
+
+func (e *PreprocessError) Unwrap() error {
+	return e.Err
+}
+
+var lineColumnRegexp = regexp.MustCompile(`(?i)\bline (\d+)(?:,? col(?:umn)? (\d+))?`)
+var templateErrorRegexp = regexp.MustCompile(`template: ([^:\s]+):(\d+)(?::(\d+))?:`)
+
+// newPreprocessError wraps err from processing source at virtualPath. An
+// error that already is a PreprocessError, e.g. from a file referenced by
+// this one, is passed on as the root cause. Otherwise the position is taken
+// from messages like "path:12:3: ..." or "line 12, column 3".
+func newPreprocessError(virtualPath string, source []byte, err error) error {
+	var preprocessErr *PreprocessError
+	if errors.As(err, &preprocessErr) {
+		if preprocessErr.Path == "" {
+			preprocessErr.Path = virtualPath
+		}
+		if preprocessErr.Snippet == "" && preprocessErr.Path == virtualPath {
+			preprocessErr.Snippet = snippet(source, preprocessErr.Line)
+		}
+		return preprocessErr
+	}
+
+	preprocessErr = &PreprocessError{Path: virtualPath, Err: err}
+	message := err.Error()
+	match := regexp.MustCompile(regexp.QuoteMeta(virtualPath) + `:(\d+)(?::(\d+))?`).FindStringSubmatch(message)
+	if match == nil {
+		match = lineColumnRegexp.FindStringSubmatch(message)
+	}
+	if match != nil {
+		preprocessErr.Line, _ = strconv.Atoi(match[1])
+		preprocessErr.Column, _ = strconv.Atoi(match[2])
+		preprocessErr.Snippet = snippet(source, preprocessErr.Line)
+	}
+	return preprocessErr
+}
+
+// templateError locates a template parse or execution error in the source
+// of the template it names.
+func (f *Assets) templateError(err error) error {
+	var preprocessErr *PreprocessError
+	if errors.As(err, &preprocessErr) {
+		return err
+	}
+	match := templateErrorRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	file, getErr := f.Get(match[1])
+	if getErr != nil {
+		return err
+	}
+	return newPreprocessError(match[1], file.Content, err)
+}
+
+// TemplateError is a template failing to parse or render in RenderTemplate,
+// with what was being rendered, for error pages.
+type TemplateError struct {
+	Templates []string    // the template set, including partials
+	Name      string      // the template rendered
+	Data      interface{} // the data passed to it
+	Err       error       // usually a *PreprocessError locating the error
+}
+
+func (e *TemplateError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+func (f *Assets) newTemplateError(templatePathArr []string, name string, data interface{}, err error) error {
+	return &TemplateError{Templates: f.withPartials(templatePathArr), Name: name, Data: data, Err: err}
+}
+
+// dump formats data for an error page.
+func dump(data interface{}) string {
+	if dumped, err := json.MarshalIndent(data, "", "  "); err == nil {
+		return string(dumped)
+	}
+	return fmt.Sprintf("%#v", data)
+}
+
+// snippet returns the lines around line, numbered, with line marked.
+func snippet(source []byte, line int) string {
+	lines := strings.Split(string(source), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	from, to := line-3, line+2
+	if from < 1 {
+		from = 1
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+	for i := from; i <= to; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&buf, "%v %4d | %v\n", marker, i, lines[i-1])
+	}
+	return buf.String()
+}
+
+var devErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Code}} - {{.Message}}</title>
+<style>body{font-family:sans-serif;margin:2em}pre{background:#f6f6f6;padding:1em;overflow:auto}.location{color:#a00}</style>
+</head><body>
+<h1>{{.Code}}</h1>
+{{with .Preprocess}}<p class="location">{{.Path}}{{if .Line}}:{{.Line}}{{if .Column}}:{{.Column}}{{end}}{{end}}</p>
+<p>{{.Err}}</p>
+{{if .Snippet}}<pre>{{.Snippet}}</pre>{{end}}{{else}}<pre>{{.Message}}</pre>{{end}}
+{{with .Template}}<h2>Rendering {{.Name}}</h2>
+<ol class="templates">{{range .Templates}}<li>{{.}}</li>{{end}}</ol>
+<h2>Data</h2>
+<pre>{{$.Data}}</pre>{{end}}
+</body></html>
+`))
+
+// DevErrorRenderer renders errors as an html page for development, showing
+// the location and source of a PreprocessError, and the templates and data
+// of a TemplateError. NewSite uses it for development sites.
+func DevErrorRenderer(w http.ResponseWriter, r *http.Request, code int, err error) {
+	data := struct {
+		Code       int
+		Message    string
+		Preprocess *PreprocessError
+		Template   *TemplateError
+		Data       string
+	}{Code: code, Message: err.Error()}
+	errors.As(err, &data.Preprocess)
+	if errors.As(err, &data.Template) {
+		data.Data = dump(data.Template.Data)
+	}
+
+	var buf bytes.Buffer
+	if renderErr := devErrorTemplate.Execute(&buf, data); renderErr != nil {
+		httpError(w, code, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
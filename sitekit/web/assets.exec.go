@@ -0,0 +1,64 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultExecTimeout is the timeout used by ExecPreprocessor.
+var DefaultExecTimeout = time.Second * 30
+
+// ExecPreprocessor returns a Preprocessor that pipes content through an
+// external command (sassc, esbuild, terser, ...) and uses its output. The
+// string "{path}" in args is replaced with the virtual path of the file.
+func ExecPreprocessor(cmd string, args ...string) Preprocessor {
+	return ExecPreprocessorTimeout(DefaultExecTimeout, cmd, args...)
+}
+
+// ExecPreprocessorTimeout is ExecPreprocessor with an explicit timeout.
+func ExecPreprocessorTimeout(timeout time.Duration, cmd string, args ...string) Preprocessor {
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return assets.runCommandCached(timeout, content, cmd, expandArgs(args, path)...)
+	}
+}
+
+func expandArgs(args []string, path string) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = strings.Replace(arg, "{path}", path, -1)
+	}
+	return expanded
+}
+
+// runCommandCached is runCommand, with the output kept in Assets.Cache.
+func (f *Assets) runCommandCached(timeout time.Duration, input []byte, cmd string, args ...string) ([]byte, error) {
+	step := strings.Join(append([]string{cmd}, args...), "\x00")
+	return f.cached(step, input, func() ([]byte, error) {
+		return runCommand(timeout, input, cmd, args...)
+	})
+}
+
+// runCommand runs cmd with input on stdin and returns its stdout. Errors
+// include whatever the command wrote to stderr.
+func runCommand(timeout time.Duration, input []byte, cmd string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	command := exec.CommandContext(ctx, cmd, args...)
+	command.Stdin = bytes.NewReader(input)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", timeout)
+		}
+		return nil, fmt.Errorf("%v: %v: %v", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,42 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// AddTemplateExpansion runs files with the given extensions (".css" and ".js"
+// if none) through text/template with data, before any other preprocessing,
+// so build-time constants like colors, feature flags and api endpoints can be
+// injected: color: {{.Brand}}; fetch("{{.API}}/items"). The asset template
+// funcs are available too.
+func (f *Assets) AddTemplateExpansion(data interface{}, extensions ...string) {
+	if len(extensions) == 0 {
+		extensions = []string{".css", ".js"}
+	}
+	processor := TemplateExpansionPreprocessor(data)
+	for _, extension := range extensions {
+		f.AddPreprocessorRule(PreprocessorRule{Extension: extension, Stage: StageExpand, Processor: processor})
+	}
+}
+
+// TemplateExpansionPreprocessor returns a Preprocessor executing content as a
+// text/template with data. Missing keys in a data map are an error.
+func TemplateExpansionPreprocessor(data interface{}) Preprocessor {
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		assets.lock.RLock()
+		funcs := template.FuncMap(assets.templateFuncMap)
+		t, err := template.New(path).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+		assets.lock.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Export processes every registered file and writes it to dir at its url
+// below the base url, so a CDN or web server can serve the assets while the
+// application only renders html. Next to each file go its compressed
+// variants (.gz, .zst and .br) and at the root the manifest.json to load
+// with LoadManifest. Brotli variants come from sidecar files only, as there
+// is no brotli encoder. Source maps are left out unless SourceMapsPublic.
+func (f *Assets) Export(dir string) error {
+	if err := f.loadAll(context.Background(), f.Paths()); err != nil {
+		return err
+	}
+
+	err := f.Walk(func(virtualPath string, file *File) error {
+		if !f.exports(virtualPath) {
+			return nil
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(f.exportName(file)))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, file.Content, 0644); err != nil {
+			return err
+		}
+
+		variants, err := f.exportVariants(file)
+		if err != nil {
+			return err
+		}
+		for _, variant := range variants {
+			if err := ioutil.WriteFile(target+variant.extension, variant.content, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest, err := f.Manifest()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "manifest.json"), manifest, 0644)
+}
+
+// exports reports whether Export and Sync publish virtualPath.
+func (f *Assets) exports(virtualPath string) bool {
+	return f.sourceMapPolicy() == SourceMapsPublic || !strings.HasSuffix(virtualPath, ".map")
+}
+
+// exportName returns the path of file relative to the base url.
+func (f *Assets) exportName(file *File) string {
+	name := strings.TrimPrefix(f.fileURL(file), f.baseURL)
+	if i := strings.Index(name, "?"); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+// exportVariant is a compressed copy of a file, stored next to it with
+// extension appended to its name.
+type exportVariant struct {
+	extension string
+	encoding  string // Content-Encoding
+	content   []byte
+}
+
+// exportVariants returns the compressed copies of file worth publishing,
+// compressing streamed files, which have none in memory.
+func (f *Assets) exportVariants(file *File) ([]exportVariant, error) {
+	gzipped, zstd := file.ContentGZipped, file.ContentZstd
+	if file.streamed {
+		var err error
+		if gzipped, err = f.Compression.gzip(file.Content); err != nil {
+			return nil, err
+		}
+		if f.Compression.Zstd {
+			zstd = f.Compression.zstd(file.Content)
+		}
+	}
+
+	var variants []exportVariant
+	for _, variant := range []exportVariant{{".gz", "gzip", gzipped}, {".zst", "zstd", zstd}, {".br", "br", file.ContentBrotli}} {
+		if variant.content != nil {
+			variants = append(variants, variant)
+		}
+	}
+	return variants, nil
+}
@@ -0,0 +1,155 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FontSubsetConfig configures font subsetting. The characters kept are
+// Unicodes if set, or else those found in the Templates plus printable ascii,
+// since the data rendered into the templates isn't known up front.
+type FontSubsetConfig struct {
+	Unicodes  string   // ranges like "U+0000-00FF,U+2013"
+	Templates []string // globs of virtual paths, e.g. "/templates/**"
+
+	// Command runs the subsetter with "{input}", "{output}" and "{unicodes}"
+	// in the arguments replaced. By default pyftsubset from fonttools,
+	// keeping the format of the font.
+	Command []string
+	Timeout time.Duration // 30 seconds by default
+}
+
+// AddFontSubsetPreprocessors subsets .ttf, .otf, .woff and .woff2 fonts.
+func (f *Assets) AddFontSubsetPreprocessors(config FontSubsetConfig) {
+	processor := FontSubsetPreprocessor(config)
+	for _, extension := range []string{".ttf", ".otf", ".woff", ".woff2"} {
+		f.AddPreprocessorRule(PreprocessorRule{Extension: extension, Stage: StageMinify, Processor: processor})
+	}
+}
+
+// FontSubsetPreprocessor returns a Preprocessor that removes the glyphs of a
+// font that are not needed. When subsetting by Templates, the templates are
+// recorded as sources of the font, so changing one subsets the font again.
+func FontSubsetPreprocessor(config FontSubsetConfig) Preprocessor {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultExecTimeout
+	}
+
+	return func(assets *Assets, virtualPath string, content []byte) ([]byte, error) {
+		unicodes := config.Unicodes
+		if unicodes == "" {
+			var err error
+			if unicodes, err = assets.templateUnicodes(virtualPath, config.Templates); err != nil {
+				return nil, err
+			}
+		}
+
+		command := config.Command
+		if command == nil {
+			command = []string{"pyftsubset", "{input}", "--unicodes={unicodes}", "--output-file={output}"}
+			switch path.Ext(virtualPath) {
+			case ".woff":
+				command = append(command, "--flavor=woff")
+			case ".woff2":
+				command = append(command, "--flavor=woff2")
+			}
+		}
+
+		step := "fontsubset\x00" + strings.Join(command, "\x00") + "\x00" + unicodes
+		return assets.cached(step, content, func() ([]byte, error) {
+			return subsetFont(config.Timeout, content, path.Ext(virtualPath), unicodes, command)
+		})
+	}
+}
+
+// templateUnicodes returns the unicode ranges of the characters in the
+// sources of the files matching the template globs.
+func (f *Assets) templateUnicodes(virtualPath string, templates []string) (string, error) {
+	used := make(map[rune]bool)
+	for r := rune(0x20); r < 0x7f; r++ {
+		used[r] = true
+	}
+
+	var sources []string
+	for _, pattern := range templates {
+		matches, err := f.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		for _, match := range matches {
+			content, err := f.readSource(match)
+			if err != nil {
+				return "", err
+			}
+			for len(content) > 0 {
+				r, size := utf8.DecodeRune(content)
+				if r != utf8.RuneError && r >= 0x20 {
+					used[r] = true
+				}
+				content = content[size:]
+			}
+			sources = append(sources, match)
+		}
+	}
+	f.setSources(virtualPath, sources)
+
+	runes := make([]rune, 0, len(used))
+	for r := range used {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	var ranges []string
+	for i := 0; i < len(runes); {
+		j := i
+		for j+1 < len(runes) && runes[j+1] == runes[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprintf("U+%04X", runes[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("U+%04X-%04X", runes[i], runes[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ","), nil
+}
+
+// subsetFont runs command on a temporary copy of the font and returns the
+// file it writes.
+func subsetFont(timeout time.Duration, content []byte, extension string, unicodes string, command []string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "fontsubset")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input, output := filepath.Join(dir, "input"+extension), filepath.Join(dir, "output"+extension)
+	if err := ioutil.WriteFile(input, content, 0644); err != nil {
+		return nil, err
+	}
+	replacer := strings.NewReplacer("{input}", input, "{output}", output, "{unicodes}", unicodes)
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", timeout)
+		}
+		return nil, fmt.Errorf("%v: %v: %v", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return ioutil.ReadFile(output)
+}
@@ -0,0 +1,167 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+)
+
+// StandardFuncs returns commonly needed template funcs. They are not
+// registered by default; see AddStandardFuncs.
+//
+//	formatdate "2006-01-02" .Time    formats a time.Time
+//	number 1234567                   "1,234,567"
+//	bytes 1536000                    "1.5 MB"
+//	truncate 20 .Text                cuts text to 20 characters, adding "…"
+//	markdownify .Text                renders markdown to html
+//	dict "key" value ...             builds a map, e.g. for passing to templates
+//	list a b c                       builds a slice
+//	default "none" .Value            .Value, or "none" if it is empty
+//	safehtml, safeattr, safeurl,     marks a string as trusted, so it isn't
+//	safejs, safecss                  escaped
+func StandardFuncs() template.FuncMap {
+	return template.FuncMap{
+		"formatdate": func(layout string, t time.Time) string { return t.Format(layout) },
+		"number":     formatNumber,
+		"bytes":      formatBytes,
+		"truncate":   truncate,
+		"markdownify": func(text string) (template.HTML, error) {
+			var buf bytes.Buffer
+			if err := goldmark.Convert([]byte(text), &buf); err != nil {
+				return "", err
+			}
+			return template.HTML(buf.String()), nil
+		},
+		"dict":     dict,
+		"list":     func(values ...interface{}) []interface{} { return values },
+		"default":  defaultValue,
+		"safehtml": func(s string) template.HTML { return template.HTML(s) },
+		"safeattr": func(s string) template.HTMLAttr { return template.HTMLAttr(s) },
+		"safeurl":  func(s string) template.URL { return template.URL(s) },
+		"safejs":   func(s string) template.JS { return template.JS(s) },
+		"safecss":  func(s string) template.CSS { return template.CSS(s) },
+	}
+}
+
+// AddStandardFuncs registers the StandardFuncs, replacing any template funcs
+// of the same names.
+func (f *Assets) AddStandardFuncs() {
+	for name, templateFunc := range StandardFuncs() {
+		f.SetTemplateFunc(name, templateFunc)
+	}
+}
+
+// toFloat converts any number to a float64.
+func toFloat(value interface{}) (float64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
+
+// formatNumber formats a number with thousands separators, keeping up to two
+// decimals for fractions.
+func formatNumber(value interface{}) (string, error) {
+	n, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+
+	formatted := strconv.FormatFloat(n, 'f', -1, 64)
+	if n != float64(int64(n)) {
+		formatted = strings.TrimRight(strings.TrimRight(strconv.FormatFloat(n, 'f', 2, 64), "0"), ".")
+	}
+	sign := ""
+	if strings.HasPrefix(formatted, "-") {
+		sign, formatted = "-", formatted[1:]
+	}
+	integer, fraction := formatted, ""
+	if dot := strings.Index(formatted, "."); dot != -1 {
+		integer, fraction = formatted[:dot], formatted[dot:]
+	}
+	for i := len(integer) - 3; i > 0; i -= 3 {
+		integer = integer[:i] + "," + integer[i:]
+	}
+	return sign + integer + fraction, nil
+}
+
+// formatBytes formats a size in bytes with SI units: 1.5 kB, 12 MB.
+func formatBytes(value interface{}) (string, error) {
+	n, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	unit := 0
+	for n >= 1000 && unit < len(units)-1 {
+		n /= 1000
+		unit++
+	}
+	if unit == 0 || n >= 10 {
+		return fmt.Sprintf("%.0f %v", n, units[unit]), nil
+	}
+	return strings.Replace(fmt.Sprintf("%.1f %v", n, units[unit]), ".0 ", " ", 1), nil
+}
+
+// truncate cuts text to at most length characters, ending with "…" when cut.
+func truncate(length int, text string) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	if length < 1 {
+		return ""
+	}
+	runes := []rune(text)
+	return strings.TrimRight(string(runes[:length-1]), " ") + "…"
+}
+
+// dict builds a map from key, value pairs.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict needs key, value pairs")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %v", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// defaultValue returns value, or fallback if value is missing or the zero
+// value of its type.
+func defaultValue(fallback interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || value[0] == nil {
+		return fallback
+	}
+	v := reflect.ValueOf(value[0])
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		if v.Len() == 0 {
+			return fallback
+		}
+	default:
+		if v.IsZero() {
+			return fallback
+		}
+	}
+	return value[0]
+}
@@ -2,24 +2,33 @@ package web
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"html/template"
 	"io/ioutil"
 	"math/rand"
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime/pprof"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/oliverkofoed/gokit/logkit"
 	"github.com/tdewolff/minify"
 	"github.com/tdewolff/minify/css"
 	"github.com/tdewolff/minify/html"
@@ -30,44 +39,229 @@ import (
 type Preprocessor func(assets *Assets, path string, content []byte) (result []byte, err error)
 
 type Assets struct {
-	version              int
+	version              int64 // changes to files; atomic, first for 64-bit alignment
 	baseURL              string
 	lock                 sync.RWMutex
-	preprocessors        map[string][]Preprocessor
-	entries              map[string]*File
+	preprocessors        []PreprocessorRule
+	entries              map[string]*assetEntry
+	aliases              map[string]string
+	directories          map[string]string
+	watcher              *fsnotify.Watcher
+	changeListeners      map[chan string]bool
+	modeOverride         int32 // Mode+1 once SetDevMode is called; atomic
+	dependencies         map[string][]string
+	logContext           atomic.Value // *logkit.Context, see SetLogOutput
+	publicURL            atomic.Value // string, see SetPublicURL
+	partialPaths         atomic.Value // partialPaths, see withPartials
+	sources              map[string][]string
+	contentTypes         map[string]string
+	responsive           map[string][]responsiveImage
+	variantSources       map[string]string // image variant path to HashString of the image it was made from
+	frontmatter          map[string]map[string]string
+	bundles              map[string][]string
+	chunks               map[string][]string
+	layouts              map[string]string
+	templateSets         map[string][]string
+	partials             []string
+	translations         []string
+	catalogs             map[string]*catalog
+	processedHooks       []func(path string, file *File)
+	manifest             Manifest
 	byChecksum           map[string]*File
 	templateCache        map[string]*template.Template
-	templateCacheVersion int
+	textTemplateCache    map[string]*texttemplate.Template
+	templateCacheMembers map[string]map[string]bool
+	templateCalls        map[string]*templateCall
+	contextTemplates     map[string]*contextTemplates
 	templateFuncMap      template.FuncMap
+	trustedFuncs         map[string]bool
+	safeFuncs            bool
+	templateDelims       [2]string
+	templateMinify       map[string]*HTMLMinifyOptions
+	templateTrim         map[string]bool
+	templateMeta         map[string]*templateMeta
+	streamCache          *compressedCache
+	Mode                 Mode               // selects the development or production only behavior. Set before loading files, or switch with SetDevMode.
+	MinifyTemplates      *HTMLMinifyOptions // minifies rendered template output; see SetTemplateMinify for per template settings.
+	BuildWorkers         int
+	Compression          CompressionConfig
+	CachePolicy          CachePolicy
+	CORS                 []CORSConfig
+	NotFoundHandler      http.Handler
+	ErrorRenderer        ErrorRenderer
+	DownloadPrefixes     []string
+	SecurityHeaders      SecurityHeaders
+	HashFunc             func() hash.Hash // fingerprint hash, sha1.New by default. Set before loading files.
+	HashLength           int              // hex characters of the hash used in urls, 0 for all.
+	URLFormat            URLFormat
+	InlineMaxSize        int        // css references to assets of at most this many bytes become data: uris, 0 disables.
+	InlinePatterns       []string   // globs of virtual paths that are always inlined in css.
+	Cache                *DiskCache // keeps expensive preprocessing results across restarts, nil disables.
+	Budgets              []SizeBudget
+	EnforceBudgets       bool   // makes BuildAll fail with a *BudgetError when over budget, rather than log a warning.
+	DefaultLocale        string // locale of the t template func, when not rendering for a specific locale.
+	SourceMaps           SourceMapPolicy
+	SourceMapAuth        func(r *http.Request) bool // allows requests for source maps with SourceMapsPrivate.
 }
 
+// File is a loaded and processed asset. It is shared by everyone getting the
+// asset and never changed after loading; re-adding the asset loads a new File.
 type File struct {
 	path           string
+	virtualPath    string
+	options        FileOptions
+	streamed       bool
+	checksum       string
 	Content        []byte
 	ContentGZipped []byte
+	ContentZstd    []byte
+	ContentBrotli  []byte
 	Hash           []byte
 	HashString     string
+	Integrity      string
 	ContentType    string
 }
 
-func NewAssets(baseURL string) Assets {
-	assets := Assets{
+// assetEntry is a file registered at a virtual path. It is loaded at most
+// once at a time, and the File it produces is kept until the entry is
+// replaced.
+type assetEntry struct {
+	load        sync.Mutex // held while loading
+	path        string
+	generated   []byte                 // content of files added with AddContent, which have no path
+	generate    func() ([]byte, error) // produces the content of bundles when loaded
+	virtualPath string
+	options     FileOptions
+	file        *File        // the loaded file, guarded by Assets.lock
+	loaded      time.Time    // when the source of file was read, guarded by Assets.lock
+	report      *AssetReport // how file was built, guarded by Assets.lock
+}
+
+// reset returns an unloaded copy of the entry.
+func (e *assetEntry) reset() *assetEntry {
+	return &assetEntry{path: e.path, generated: e.generated, generate: e.generate, virtualPath: e.virtualPath, options: e.options}
+}
+
+// read returns the unprocessed content of the file.
+func (e *assetEntry) read() ([]byte, error) {
+	if e.generate != nil {
+		return e.generate()
+	}
+	if e.path == "" {
+		return e.generated, nil
+	}
+	return ioutil.ReadFile(e.path)
+}
+
+// FileOptions overrides how a single file is served. Empty values fall back
+// to the defaults (mime detection and the standard cache headers).
+type FileOptions struct {
+	ContentType  string
+	Headers      http.Header
+	CacheControl string
+
+	// Download serves the file as an attachment, named Filename or the base
+	// name of the virtual path.
+	Download bool
+	Filename string
+}
+
+func NewAssets(baseURL string) *Assets {
+	assets := &Assets{
 		version:              0,
 		baseURL:              baseURL,
-		preprocessors:        make(map[string][]Preprocessor),
-		entries:              make(map[string]*File),
+		entries:              make(map[string]*assetEntry),
+		aliases:              make(map[string]string),
+		directories:          make(map[string]string),
+		changeListeners:      make(map[chan string]bool),
+		dependencies:         make(map[string][]string),
+		sources:              make(map[string][]string),
+		contentTypes:         make(map[string]string),
+		responsive:           make(map[string][]responsiveImage),
+		variantSources:       make(map[string]string),
+		frontmatter:          make(map[string]map[string]string),
+		bundles:              make(map[string][]string),
+		chunks:               make(map[string][]string),
+		layouts:              make(map[string]string),
+		templateSets:         make(map[string][]string),
+		catalogs:             make(map[string]*catalog),
 		byChecksum:           make(map[string]*File),
+		streamCache:          newCompressedCache(),
 		templateCache:        make(map[string]*template.Template),
-		templateCacheVersion: 0,
+		textTemplateCache:    make(map[string]*texttemplate.Template),
+		templateCacheMembers: make(map[string]map[string]bool),
+		templateCalls:        make(map[string]*templateCall),
+		contextTemplates:     make(map[string]*contextTemplates),
+		templateMinify:       make(map[string]*HTMLMinifyOptions),
+		templateTrim:         make(map[string]bool),
+		templateMeta:         make(map[string]*templateMeta),
+		Compression:          DefaultCompressionConfig(),
+		CachePolicy:          DefaultCachePolicy(),
+		HashFunc:             sha1.New,
 	}
 	assets.templateFuncMap = template.FuncMap{
-		"jscode": func(input string) template.JS { return template.JS(input) },
+		"jscode":      func(input string) template.JS { return template.JS(input) },
+		"production":  func() bool { return assets.currentMode() == ModeProduction },
+		"development": func() bool { return assets.currentMode() == ModeDevelopment },
+		"context":     func() context.Context { return context.Background() },
+		"flush":       func() string { return "" },
 		"asset": func(virtualPath string) (string, error) {
 			if virtualPath[0] != '/' {
 				return "", errors.New("path argument must start with '/'")
 			}
 			return assets.GetUrl(virtualPath)
 		},
+		"assetglob": func(pattern string) ([]string, error) {
+			return assets.Glob(pattern)
+		},
+		"script": func(virtualPath string) (template.HTML, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.ScriptTag(virtualPath)
+		},
+		"stylesheet": func(virtualPath string) (template.HTML, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.StylesheetTag(virtualPath)
+		},
+		"srcset": func(virtualPath string) (template.HTMLAttr, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.SrcSet(virtualPath)
+		},
+		"inlinesvg": func(virtualPath string, attributes ...string) (template.HTML, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.InlineSVG(virtualPath, attributes...)
+		},
+		"markdown": func(virtualPath string) (template.HTML, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.Markdown(virtualPath)
+		},
+		"frontmatter": func(virtualPath string) (map[string]string, error) {
+			if virtualPath[0] != '/' {
+				return nil, errors.New("path argument must start with '/'")
+			}
+			return assets.Frontmatter(virtualPath)
+		},
+		"bundle": func(virtualPath string) (template.HTML, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.BundleTag(virtualPath)
+		},
+		"picture": func(virtualPath string, alt string) (template.HTML, error) {
+			if virtualPath[0] != '/' {
+				return "", errors.New("path argument must start with '/'")
+			}
+			return assets.PictureTag(virtualPath, alt)
+		},
 		"assetinline": func(virtualPath string) (string, error) {
 			if virtualPath[0] != '/' {
 				return "", errors.New("path argument must start with '/'")
@@ -78,10 +272,34 @@ func NewAssets(baseURL string) Assets {
 			}
 			return string(file.Content), nil
 		},
+		"inlinecritical": inlineCriticalPlaceholder,
+		"t": func(key string, args ...interface{}) string {
+			return assets.Translate("", key, args...)
+		},
+		"asset_preload":  func(virtualPaths ...string) string { return "" },
+		"asset_prefetch": func(virtualPaths ...string) string { return "" },
+		"preloadlinks":   func() template.HTML { return preloadLinksPlaceholder },
+		"livereload":     assets.liveReloadScript,
+		"bundlemanifest": func(virtualPath string) (BundleManifestEntry, error) {
+			if virtualPath[0] != '/' {
+				return BundleManifestEntry{}, errors.New("path argument must start with '/'")
+			}
+			return assets.GetBundle(virtualPath)
+		},
 	}
 
-	assets.AddPreprocessor(".css", AssetCssPreprocessor)
-	assets.AddPreprocessor(".css", AssetSourceMapPreprocessor)
+	// the asset funcs build their html safely, while jscode passes on anything
+	assets.trustedFuncs = make(map[string]bool)
+	for name := range assets.templateFuncMap {
+		assets.trustedFuncs[name] = name != "jscode"
+	}
+
+	assets.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageResolve - 1, Processor: CSSImportPreprocessor})
+	assets.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageResolve, Processor: AssetCssPreprocessor})
+	assets.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageResolve, Processor: AssetSourceMapPreprocessor})
+	assets.AddPreprocessorRule(PreprocessorRule{Extension: ".js", Stage: StageResolve, Processor: SourceMapStripPreprocessor})
+	assets.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageMinify, Processor: ProductionOnly(CSSMinifyPreprocessor)})
+	assets.AddPreprocessorRule(PreprocessorRule{Extension: ".scss", Stage: StageMinify, Processor: ProductionOnly(CSSMinifyPreprocessor)})
 
 	return assets
 }
@@ -99,17 +317,17 @@ func (f *Assets) AddMinifyPreprocessors(minifyCSS, minifyJavascript, minifySVG,
 	}
 	if minifyJavascript {
 		m.AddFunc("text/javascript", js.Minify)
-		f.AddPreprocessor(".js", minifier("text/javascript"))
+		f.AddPreprocessorRule(PreprocessorRule{Extension: ".js", Stage: StageMinify, Processor: minifier("text/javascript")})
 	}
 	if minifySVG {
 		m.AddFunc("image/svg+xml", svg.Minify)
-		f.AddPreprocessor(".svg", minifier("image/svg+xml"))
+		f.AddPreprocessorRule(PreprocessorRule{Extension: ".svg", Stage: StageMinify, Processor: minifier("image/svg+xml")})
 	}
 	if minifyCSS {
 		m.Add("text/css", &css.Minifier{
 			Decimals: -1,
 		})
-		f.AddPreprocessor(".css", minifier("text/css"))
+		f.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageMinify, Processor: minifier("text/css")})
 	}
 	if minifyHTML {
 		m.Add("text/html", &html.Minifier{
@@ -117,8 +335,8 @@ func (f *Assets) AddMinifyPreprocessors(minifyCSS, minifyJavascript, minifySVG,
 			KeepDocumentTags:    true,
 			KeepEndTags:         true,
 		})
-		f.AddPreprocessor(".htm", minifier("text/html"))
-		f.AddPreprocessor(".html", minifier("text/html"))
+		f.AddPreprocessorRule(PreprocessorRule{Extension: ".htm", Stage: StageMinify, Processor: minifier("text/html")})
+		f.AddPreprocessorRule(PreprocessorRule{Extension: ".html", Stage: StageMinify, Processor: minifier("text/html")})
 	}
 	if minifyTmpl {
 		// special case for golang templates
@@ -126,7 +344,7 @@ func (f *Assets) AddMinifyPreprocessors(minifyCSS, minifyJavascript, minifySVG,
 		golangTagRegexp := regexp.MustCompile("{{[^}]+}}")
 		placeholdertag := regexp.MustCompile("placeholder[a-z]+?placeholder")
 
-		f.AddPreprocessor(".tmpl", func(assets *Assets, path string, content []byte) ([]byte, error) {
+		tmplMinifier := func(assets *Assets, path string, content []byte) ([]byte, error) {
 			store := make(map[string][]byte)
 
 			// replace golang template tags with placeholders
@@ -154,19 +372,63 @@ func (f *Assets) AddMinifyPreprocessors(minifyCSS, minifyJavascript, minifySVG,
 			})
 
 			return minified, nil
-		})
+		}
+		f.AddPreprocessorRule(PreprocessorRule{Extension: ".tmpl", Stage: StageMinify, Processor: tmplMinifier})
 	}
 }
 
+// SetTemplateDelims sets the action delimiters of templates, e.g. "[[" and
+// "]]" for templates also containing {{ }} for client side templating. Empty
+// delimiters are "{{" and "}}".
+func (f *Assets) SetTemplateDelims(left string, right string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.templateDelims = [2]string{left, right}
+	atomic.AddInt64(&f.version, 1)
+	f.resetTemplates()
+}
+
+// TemplateDelims returns the action delimiters of templates.
+func (f *Assets) TemplateDelims() (left string, right string) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.templateDelims[0], f.templateDelims[1]
+}
+
+// SetTemplateFunc makes templateFunc available to templates as name. It
+// panics if SafeFuncs is on and templateFunc bypasses escaping.
 func (f *Assets) SetTemplateFunc(name string, templateFunc interface{}) {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
+	if f.safeFuncs && !f.trustedFuncs[name] && bypassesEscaping(templateFunc) {
+		panic(fmt.Sprintf("template func %v returns content html/template doesn't escape; allow it with SafeFuncs", name))
+	}
 	f.templateFuncMap[name] = templateFunc
+	atomic.AddInt64(&f.version, 1)
+	f.resetTemplates()
+}
+
+// SetContentType sets the content type served for files with extension
+// (".scss"), for sources that are compiled into another type.
+func (f *Assets) SetContentType(extension string, contentType string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.contentTypes[extension] = contentType
 }
 
 func (f *Assets) AddDirectory(directory string, virtualPath string) error {
+	f.lock.Lock()
+	f.directories[filepath.Clean(directory)] = virtualPath
+	f.lock.Unlock()
+
 	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if !info.IsDir() {
 			rel, err := filepath.Rel(directory, path)
 			if err != nil {
@@ -180,130 +442,416 @@ func (f *Assets) AddDirectory(directory string, virtualPath string) error {
 	})
 }
 
-func (f *Assets) AddPreprocessor(extension string, processor Preprocessor) {
+func (f *Assets) AddFile(file string, virtualPath string) {
+	f.AddFileWithOptions(file, virtualPath, FileOptions{})
+}
+
+func (f *Assets) AddFileWithOptions(file string, virtualPath string, options FileOptions) {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
-	preprocessors := f.preprocessors[extension]
-	if preprocessors == nil {
-		preprocessors = make([]Preprocessor, 0, 10)
+	delete(f.aliases, virtualPath)
+	delete(f.bundles, virtualPath)
+	delete(f.chunks, virtualPath)
+	f.entries[virtualPath] = &assetEntry{
+		path:        file,
+		virtualPath: virtualPath,
+		options:     options,
 	}
-
-	f.preprocessors[extension] = append(preprocessors, processor)
+	if f.watcher != nil {
+		f.watcher.Add(filepath.Dir(file))
+	}
+	f.invalidateDependents(virtualPath, make(map[string]bool))
+	atomic.AddInt64(&f.version, 1)
 }
 
-func (f *Assets) ClearPreprocessors(extension string) {
+// AddContent registers content generated in memory, such as source maps or
+// image variants, at virtualPath.
+func (f *Assets) AddContent(virtualPath string, content []byte, options FileOptions) {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
-	delete(f.preprocessors, extension)
+	delete(f.aliases, virtualPath)
+	delete(f.bundles, virtualPath)
+	delete(f.chunks, virtualPath)
+	f.entries[virtualPath] = &assetEntry{
+		generated:   content,
+		virtualPath: virtualPath,
+		options:     options,
+	}
+	f.invalidateDependents(virtualPath, make(map[string]bool))
+	atomic.AddInt64(&f.version, 1)
 }
 
-func (f *Assets) AddFile(file string, virtualPath string) {
+// Alias makes virtualPath resolve to the same File as target, sharing its
+// processed content, hash and compressed buffers.
+func (f *Assets) Alias(virtualPath string, target string) error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
-	f.entries[virtualPath] = &File{
-		path: file,
+	if aliasTarget, found := f.aliases[target]; found {
+		target = aliasTarget
+	}
+	if f.entries[target] == nil {
+		return errors.New("File Not Found: " + target)
 	}
-	f.version++
+
+	delete(f.entries, virtualPath)
+	f.aliases[virtualPath] = target
+	f.evictTemplates(virtualPath)
+	atomic.AddInt64(&f.version, 1)
+	return nil
+}
+
+// lookup finds the file registered for virtualPath, following aliases.
+// The caller must hold the lock.
+func (f *Assets) lookup(virtualPath string) *assetEntry {
+	if target, found := f.aliases[virtualPath]; found {
+		virtualPath = target
+	}
+	return f.entries[virtualPath]
 }
 
 func (f *Assets) Get(virtualPath string) (*File, error) {
+	return f.get(context.Background(), virtualPath)
+}
+
+// get is Get, loading the file with the pprof labels of ctx.
+func (f *Assets) get(ctx context.Context, virtualPath string) (*File, error) {
+	if f.currentMode() == ModeDevelopment {
+		f.reloadChanged(virtualPath, make(map[string]bool))
+	}
+
 	f.lock.RLock()
-	file := f.entries[virtualPath]
+	entry := f.lookup(virtualPath)
+	var file *File
+	if entry != nil {
+		file = entry.file
+	}
 	f.lock.RUnlock()
-	if file == nil {
+	if entry == nil {
 		return nil, errors.New("File Not Found: " + virtualPath)
 	}
+	if file != nil {
+		return file, nil
+	}
 
-	if file.Content == nil {
-		// read file content
-		fileContent, err := ioutil.ReadFile(file.path)
-		if err != nil {
-			return nil, err
-		}
+	// concurrent first requests wait for a single load
+	entry.load.Lock()
+	defer entry.load.Unlock()
 
-		// figure out content type
-		extension := filepath.Ext(file.path)
-		file.ContentType = mime.TypeByExtension(extension)
-		if file.ContentType == "" {
-			file.ContentType = http.DetectContentType(fileContent)
+	f.lock.RLock()
+	file = entry.file
+	f.lock.RUnlock()
+	if file != nil {
+		return file, nil
+	}
+	started := time.Now()
+	file, err := f.load(ctx, entry)
+	if err != nil {
+		// preprocessor failures are logged by load, with the preprocessor
+		if !errors.As(err, new(*PreprocessError)) {
+			f.logWarn("loading asset failed", logkit.String("path", virtualPath), logkit.Err(err))
 		}
+		return nil, err
+	}
+	f.logDebug("asset loaded", logkit.String("path", virtualPath), logkit.Int("size", len(file.Content)), logkit.Duration("duration", time.Since(started)))
+	f.runProcessedHooks(entry.virtualPath, file)
+	return file, nil
+}
+
+// load processes entry into a new File and publishes it. A failed load leaves
+// the entry unloaded, so it is retried on the next Get. The caller must hold
+// entry.load.
+//
+// The stages run with the pprof labels "asset", "stage" and "preprocessor",
+// so profiles attribute their cost to the files and preprocessors involved,
+// next to the labels of ctx.
+func (f *Assets) load(ctx context.Context, entry *assetEntry) (*File, error) {
+	labels := pprof.WithLabels(f.withLogOperation(ctx), pprof.Labels("asset", entry.virtualPath))
+
+	// read file content
+	loaded := time.Now()
+	var fileContent []byte
+	var err error
+	pprof.Do(labels, pprof.Labels("stage", "read"), func(context.Context) {
+		fileContent, err = entry.read()
+	})
+	if err != nil {
+		return nil, err
+	}
+	report := &AssetReport{VirtualPath: entry.virtualPath, SourceSize: len(fileContent), Read: time.Since(loaded)}
+
+	file := &File{
+		path:        entry.path,
+		virtualPath: entry.virtualPath,
+		options:     entry.options,
+	}
 
-		// preprocess content
+	// figure out content type
+	extension := file.extension()
+	file.ContentType = file.options.ContentType
+	if file.ContentType == "" {
 		f.lock.RLock()
-		preprocessors := f.preprocessors[extension]
+		file.ContentType = f.contentTypes[extension]
 		f.lock.RUnlock()
-		if preprocessors != nil {
-			for _, processor := range preprocessors {
-				newContent, err := processor(f, virtualPath, fileContent)
-				if err != nil {
-					return nil, err
-				}
+	}
+	if file.ContentType == "" {
+		file.ContentType = mime.TypeByExtension(extension)
+	}
+	if file.ContentType == "" {
+		file.ContentType = http.DetectContentType(fileContent)
+	}
 
-				fileContent = newContent
+	// preprocess content
+	source := fileContent
+	f.lock.RLock()
+	preprocessors := f.preprocessorsFor(file.virtualPath, extension)
+	f.lock.RUnlock()
+	preprocessCtx := &PreprocessContext{
+		Context:     labels,
+		Assets:      f,
+		Path:        file.virtualPath,
+		SourcePath:  file.path,
+		ContentType: file.ContentType,
+		Mode:        f.currentMode(),
+	}
+	for _, rule := range preprocessors {
+		started := time.Now()
+		name := rule.name()
+		var newContent []byte
+		pprof.Do(labels, pprof.Labels("stage", "preprocess", "preprocessor", name), func(labeled context.Context) {
+			preprocessCtx.Context = labeled
+			if rule.ContextProcessor != nil {
+				newContent, err = rule.ContextProcessor(preprocessCtx, fileContent)
+			} else {
+				newContent, err = rule.Processor(f, file.virtualPath, fileContent)
 			}
+		})
+		if err != nil {
+			err = newPreprocessError(file.virtualPath, fileContent, err)
+			f.logWarn("preprocessor failed", logkit.String("path", file.virtualPath), logkit.String("preprocessor", name), logkit.Err(err))
+			return nil, err
 		}
+		report.Preprocessors = append(report.Preprocessors, PreprocessorTiming{Name: name, Duration: time.Since(started)})
 
-		// gzip content
-		var buffer bytes.Buffer
-		compressor := gzip.NewWriter(&buffer)
-		compressor.Write(fileContent)
-		compressor.Close()
-		file.ContentGZipped = buffer.Bytes()
+		fileContent = newContent
+	}
 
-		// sha1 the content.
-		h := sha1.New()
+	// hash the content.
+	started := time.Now()
+	pprof.Do(labels, pprof.Labels("stage", "hash"), func(context.Context) {
+		h := f.HashFunc()
 		h.Write(fileContent)
 		file.Hash = h.Sum(nil)
 		file.HashString = hex.EncodeToString(file.Hash)
-		f.lock.Lock()
-		f.byChecksum[file.HashString] = file
+		file.Integrity = integrity(fileContent)
+	})
+	file.checksum = file.HashString
+	if f.HashLength > 0 && f.HashLength < len(file.checksum) {
+		file.checksum = file.checksum[:f.HashLength]
+	}
+	file.Content = fileContent
+	report.Hashing = time.Since(started)
+	report.Hash = file.HashString
+	report.Size = len(fileContent)
+
+	// share the File of byte-identical content registered elsewhere.
+	f.lock.Lock()
+	existing := f.byChecksum[file.checksum]
+	if existing != nil && existing.HashString != file.HashString {
 		f.lock.Unlock()
+		return nil, fmt.Errorf("hash collision: %v and %v share the url hash %v", existing.virtualPath, file.virtualPath, file.checksum)
+	}
+	if existing != nil && f.isCurrent(existing) && !existing.sameAs(file) {
+		// the same content served differently gets a url of its own.
+		file.checksum += "-" + file.servingHash()
+		existing = f.byChecksum[file.checksum]
+	}
+	if existing != nil && f.isCurrent(existing) && existing.sameAs(file) {
+		report.GzippedSize = len(existing.ContentGZipped)
+		entry.file = existing
+		entry.loaded = loaded
+		entry.report = report
+		f.lock.Unlock()
+		return existing, nil
+	}
+	f.lock.Unlock()
 
-		// set the content (this is done last to minimize the chance of two goroutines in this if-statement)
-		file.Content = fileContent
+	// use precompressed sidecar files (foo.css.gz, foo.css.br) from disk,
+	// unless preprocessing changed the content they were made from or they
+	// are older than the file.
+	if file.path != "" && bytes.Equal(source, fileContent) {
+		if file.ContentGZipped, err = readSidecar(file.path, ".gz"); err != nil {
+			return nil, err
+		}
+		if file.ContentZstd, err = readSidecar(file.path, ".zst"); err != nil {
+			return nil, err
+		}
+		if file.ContentBrotli, err = readSidecar(file.path, ".br"); err != nil {
+			return nil, err
+		}
 	}
 
+	// compress content. large files are compressed while serving instead.
+	started = time.Now()
+	if f.Compression.shouldCompress(extension, fileContent) {
+		pprof.Do(labels, pprof.Labels("stage", "compress"), func(context.Context) {
+			if f.Compression.shouldStream(fileContent) && file.ContentGZipped == nil && file.ContentZstd == nil && file.ContentBrotli == nil {
+				file.streamed = true
+			} else if file.ContentGZipped == nil {
+				file.ContentGZipped, err = f.Compression.gzip(fileContent)
+			}
+			if err == nil && f.Compression.Zstd && file.ContentZstd == nil && !file.streamed {
+				file.ContentZstd = f.Compression.zstd(fileContent)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report.Compress = time.Since(started)
+	report.GzippedSize = len(file.ContentGZipped)
+
+	// publish the finished file
+	f.lock.Lock()
+	entry.file = file
+	entry.loaded = loaded
+	entry.report = report
+	f.byChecksum[file.checksum] = file
+	f.pruneChecksums()
+	f.lock.Unlock()
 	return file, nil
 }
 
-func (f *Assets) GetUrl(virtualPath string) (string, error) { //todo: returns /a/<checksum> w/ forever expires.
-	file, err := f.Get(virtualPath)
-	if err != nil {
-		return "", err
+// pruneChecksums drops the url entries of files that are no longer what any
+// path loads to, once they make up most of byChecksum. The caller must hold
+// the lock.
+func (f *Assets) pruneChecksums() {
+	if len(f.byChecksum) <= 2*len(f.entries)+16 {
+		return
+	}
+	current := make(map[*File]bool, len(f.entries))
+	for _, entry := range f.entries {
+		if entry.file != nil {
+			current[entry.file] = true
+		}
 	}
+	for checksum, file := range f.byChecksum {
+		if !current[file] {
+			delete(f.byChecksum, checksum)
+		}
+	}
+}
 
-	return f.baseURL + file.HashString, nil
+// isCurrent reports whether file is still what its virtual path loads to,
+// rather than the result of an entry that has since been replaced. The
+// caller must hold the lock.
+func (f *Assets) isCurrent(file *File) bool {
+	entry := f.lookup(file.virtualPath)
+	return entry != nil && entry.file == file
 }
 
-func (f *Assets) Serve(url string, w http.ResponseWriter, r *http.Request) {
-	if len(url) < len(f.baseURL) {
-		httpError(w, 404, "404 - File not found")
-		return
+// extension returns the extension preprocessors and cache policies are
+// selected by: that of the file on disk, or of the virtual path for
+// generated content.
+func (f *File) extension() string {
+	if f.path == "" {
+		return path.Ext(f.virtualPath)
 	}
+	return filepath.Ext(f.path)
+}
+
+// sameAs reports whether f would be served exactly like other, so the two
+// can share one File.
+func (f *File) sameAs(other *File) bool {
+	return f.HashString == other.HashString &&
+		f.ContentType == other.ContentType &&
+		reflect.DeepEqual(f.options, other.options)
+}
 
-	checksum := url[len(f.baseURL):]
+// servingHash returns a short hash of the content type and options f is
+// served with.
+func (f *File) servingHash() string {
+	h := sha1.Sum([]byte(fmt.Sprintf("%v %v", f.ContentType, f.options)))
+	return hex.EncodeToString(h[:4])
+}
+
+// Paths returns the sorted virtual paths of all registered files.
+func (f *Assets) Paths() []string {
 	f.lock.RLock()
-	file := f.byChecksum[checksum]
+	paths := make([]string, 0, len(f.entries)+len(f.aliases))
+	for virtualPath := range f.entries {
+		paths = append(paths, virtualPath)
+	}
+	for virtualPath := range f.aliases {
+		paths = append(paths, virtualPath)
+	}
 	f.lock.RUnlock()
 
-	if file == nil {
-		httpError(w, 404, "404 - File not found")
-		return
+	sort.Strings(paths)
+	return paths
+}
+
+// Walk calls walkFn with every registered file in virtual path order,
+// loading and processing files as needed. Walking stops at the first error.
+func (f *Assets) Walk(walkFn func(virtualPath string, file *File) error) error {
+	for _, virtualPath := range f.Paths() {
+		file, err := f.Get(virtualPath)
+		if err != nil {
+			return err
+		}
+
+		if err := walkFn(virtualPath, file); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", file.ContentType)
-	w.Header().Set("Cache-Control", "public, max-age=31556926")
-	w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).Format(http.TimeFormat))
+// Glob returns the sorted virtual paths matching pattern. Patterns use the
+// path.Match syntax per path segment, and "**" matches any number of segments.
+func (f *Assets) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
 
-	if r != nil && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		w.Header().Set("Content-Encoding", "gzip")
-		w.Write(file.ContentGZipped)
-	} else {
-		w.Write(file.Content)
+	matches := make([]string, 0)
+	for _, virtualPath := range f.Paths() {
+		if matchGlob(pattern, virtualPath) {
+			matches = append(matches, virtualPath)
+		}
 	}
+	return matches, nil
+}
+
+func (f *Assets) GetUrl(virtualPath string) (string, error) { //todo: returns /a/<checksum> w/ forever expires.
+	url, err := f.getURL(virtualPath)
+	return f.publicize(url), err
+}
+
+// getURL returns the url of virtualPath below the mount path, which is what
+// assets use to refer to each other.
+func (f *Assets) getURL(virtualPath string) (string, error) {
+	f.lock.RLock()
+	registered := f.lookup(virtualPath) != nil
+	manifest := f.manifest
+	f.lock.RUnlock()
+	if !registered && manifest != nil {
+		return manifest.URL(virtualPath)
+	}
+
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		// in development the page still renders, and shows the error itself
+		if url := f.loadErrorURL(virtualPath); url != "" {
+			return url, nil
+		}
+		return "", err
+	}
+
+	return f.fileURL(file), nil
 }
 
 func (f *Assets) RenderTemplateString(templatePathArr []string, data interface{}) (string, error) {
@@ -311,16 +859,20 @@ func (f *Assets) RenderTemplateString(templatePathArr []string, data interface{}
 }
 
 func (f *Assets) RenderNamedTemplateString(templatePathArr []string, name string, data interface{}) (string, error) {
-	t, err := f.GetTemplate(templatePathArr)
+	return f.renderNamedTemplateString(templatePathArr, name, "", data)
+}
+
+func (f *Assets) renderNamedTemplateString(templatePathArr []string, name string, locale string, data interface{}) (string, error) {
+	t, err := f.GetLocalizedTemplate(templatePathArr, locale)
 
 	if err != nil {
 		return "", err
 	}
 
 	buf := bytes.NewBuffer(nil)
-	err = t.ExecuteTemplate(buf, name, data)
+	err = f.executeTemplate(t, templatePathArr, name, buf, data)
 	if err != nil {
-		return "", err
+		return "", f.templateError(err)
 	}
 	return buf.String(), nil
 }
@@ -330,32 +882,49 @@ func (f *Assets) RenderTemplate(templatePathArr []string, w http.ResponseWriter,
 }
 
 func (f *Assets) RenderNamedTemplate(templatePathArr []string, name string, w http.ResponseWriter, data interface{}) error {
-	t, err := f.GetTemplate(templatePathArr)
+	return f.renderNamedTemplate(templatePathArr, name, "", w, data)
+}
+
+func (f *Assets) renderNamedTemplate(templatePathArr []string, name string, locale string, w http.ResponseWriter, data interface{}) error {
+	t, err := f.GetLocalizedTemplate(templatePathArr, locale)
 
 	if err != nil {
-		httpError(w, 500, err.Error())
+		err = f.newTemplateError(templatePathArr, name, data, err)
+		f.renderError(w, nil, http.StatusInternalServerError, err)
 		return err
 	}
 
-	err = t.ExecuteTemplate(w, name, data)
+	if err := f.addTemplateLinkHeaders(w.Header(), templatePathArr); err != nil {
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+
+	// render fully before writing, so a failing template gives a clean error
+	// page rather than one appended to half a page.
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	err = f.executeTemplate(t, templatePathArr, name, buf, data)
 	if err != nil {
-		httpError(w, 500, err.Error())
+		err = f.newTemplateError(templatePathArr, name, data, f.templateError(err))
+		f.renderError(w, nil, http.StatusInternalServerError, err)
 		return err
 	}
-	return nil
+	_, err = w.Write(buf.Bytes())
+	return err
 }
 
 func (f *Assets) GetTemplate(templatePathArr []string) (*template.Template, error) {
-	// reset cache if filesystem has changed
-	if f.version != f.templateCacheVersion {
-		f.lock.Lock()
-		f.templateCacheVersion = f.version
-		f.templateCache = make(map[string]*template.Template)
-		f.lock.Unlock()
-	}
+	return f.GetLocalizedTemplate(templatePathArr, "")
+}
+
+// GetLocalizedTemplate is GetTemplate with the t template func translating
+// to locale, the DefaultLocale if empty.
+func (f *Assets) GetLocalizedTemplate(templatePathArr []string, locale string) (*template.Template, error) {
+	templatePaths, version := f.prepareTemplates(templatePathArr)
 
 	// check cache
-	cacheKey := strings.Join(templatePathArr, "<")
+	metaKey := strings.Join(templatePathArr, "<")
+	cacheKey := metaKey + "@" + locale
 	f.lock.RLock()
 	tmpl := f.templateCache[cacheKey]
 	f.lock.RUnlock()
@@ -363,35 +932,78 @@ func (f *Assets) GetTemplate(templatePathArr []string) (*template.Template, erro
 		return tmpl, nil
 	}
 
-	// not found in cache, create new.
-	tmpl = template.New("temp-outer-template-shell").Funcs(f.templateFuncMap)
+	// not found in cache, parse it unless another goroutine already is.
+	parsed, err := f.parseOnce(cacheKey, func() (interface{}, error) {
+		tmpl, meta, err := f.parseTemplate(templatePaths, locale, f.trimsWhitespace(templatePathArr))
+		if err != nil {
+			return nil, err
+		}
+		f.lock.Lock()
+		if f.cacheTemplateSet(version, templatePaths, cacheKey, metaKey) {
+			f.templateCache[cacheKey] = tmpl
+			f.templateMeta[metaKey] = meta
+		}
+		f.lock.Unlock()
+		return tmpl, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parsed.(*template.Template), nil
+}
 
-	for _, path := range templatePathArr {
+// parseTemplate parses templatePaths into a new set, with the t func
+// translating to locale, and trims whitespace between tags if trim is set.
+func (f *Assets) parseTemplate(templatePaths []string, locale string, trim bool) (*template.Template, *templateMeta, error) {
+	funcs := f.localizedFuncs(locale)
+	tmpl := template.New("temp-outer-template-shell").Funcs(funcs)
+	meta := &templateMeta{}
+	left, right := f.TemplateDelims()
+
+	for _, path := range templatePaths {
 		if path != "" {
 			file, err := f.Get(path)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
-			temp, err := template.New(path).Funcs(f.templateFuncMap).Parse(string(file.Content))
+			temp, err := template.New(path).Delims(left, right).Funcs(funcs).Parse(string(file.Content))
 			if err != nil {
-				return nil, errors.New(path + ": " + err.Error())
+				return nil, nil, newPreprocessError(path, file.Content, err)
 			}
+			meta.placeholders = meta.placeholders || bytes.Contains(file.Content, []byte("inlinecritical")) || bytes.Contains(file.Content, []byte("preloadlinks"))
 
 			for _, t := range temp.Templates() {
 				if tmpl.Lookup(t.Name()) == nil {
 					//fmt.Println("==================> "+t.Name()+" = "+path, string(file.content), t.Tree)
 					tmpl.AddParseTree(t.Name(), t.Tree)
+					if err := meta.collectPreloads(t.Tree.Root); err != nil {
+						return nil, nil, newPreprocessError(path, file.Content, err)
+					}
 				}
 			}
 		}
 	}
 
-	f.lock.Lock()
-	f.templateCache[cacheKey] = tmpl
-	f.lock.Unlock()
+	if trim {
+		trimWhitespace(tmpl)
+	}
+	return tmpl, meta, nil
+}
+
+// prepareTemplates returns the paths to parse for templatePathArr, including
+// partials, and the version to pass to cacheTemplateSet. In ModeDevelopment
+// changed files are reloaded first.
+func (f *Assets) prepareTemplates(templatePathArr []string) ([]string, int64) {
+	templatePaths := f.withPartials(templatePathArr)
+	if f.currentMode() == ModeDevelopment {
+		seen := make(map[string]bool)
+		for _, path := range templatePaths {
+			f.reloadChanged(path, seen)
+		}
+	}
 
-	return tmpl, nil
+	return templatePaths, atomic.LoadInt64(&f.version)
 }
 
 func httpError(w http.ResponseWriter, code int, message string) {
@@ -407,24 +1019,62 @@ func (f *Assets) getRooted(fromFile string, targetFile string) (string, error) {
 	return filepath.Join(filepath.Dir(fromFile), targetFile), nil
 }
 
+func matchGlob(pattern string, virtualPath string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(virtualPath, "/"))
+}
+
+func matchGlobSegments(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchGlobSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
 // ------
 var cssUrlRegex = regexp.MustCompile(`url\([^\)]+\)`)
 var sourceMapRegex = regexp.MustCompile(`sourceMappingURL=\S+`)
+var sourceMapCommentRegex = regexp.MustCompile(`(?m)^(//[#@] sourceMappingURL=\S+|/\*[#@] sourceMappingURL=\S+ \*/)\n?`)
 
 func AssetCssPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
-	return replaceProcessor(assets, path, content, cssUrlRegex, "url(", ")")
+	return replaceProcessor(assets, path, content, cssUrlRegex, "url(", ")", true)
 }
 
 func AssetSourceMapPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
-	return replaceProcessor(assets, path, content, sourceMapRegex, "sourceMappingURL=", "")
+	if assets.sourceMapPolicy() == SourceMapsStrip {
+		return SourceMapStripPreprocessor(assets, path, content)
+	}
+	return replaceProcessor(assets, path, content, sourceMapRegex, "sourceMappingURL=", "", false)
 }
 
-func replaceProcessor(assets *Assets, path string, content []byte, regex *regexp.Regexp, prefix string, postfix string) ([]byte, error) {
+// replaceProcessor rewrites the references matched by regex to fingerprinted
+// urls. With allowInline, references to assets selected by InlineMaxSize or
+// InlinePatterns become data: uris, as do those written as "base64:path".
+func replaceProcessor(assets *Assets, path string, content []byte, regex *regexp.Regexp, prefix string, postfix string, allowInline bool) ([]byte, error) {
 	var replaceErr error = nil
 	newContent := regex.ReplaceAllFunc(content, func(match []byte) []byte {
 		//fmt.Println("Match: " + string(match))
 		file := string(match)[len(prefix) : len(match)-len(postfix)]
 
+		// inline data, external urls and urls that were resolved already are kept
+		if strings.HasPrefix(file, "data:") || strings.HasPrefix(file, "//") || urlSchemeRegexp.MatchString(file) || assets.isAssetURL(file) {
+			return match
+		}
+
 		inlineBase64 := false
 		if strings.HasPrefix(file, "base64:") {
 			file = file[7:]
@@ -439,22 +1089,26 @@ func replaceProcessor(assets *Assets, path string, content []byte, regex *regexp
 		}
 
 		// inline base64 support
-		if inlineBase64 {
+		if inlineBase64 || (allowInline && assets.inlineCandidate(rootedPath)) {
 			f, err := assets.Get(rootedPath)
 			if err != nil {
 				replaceErr = err
 				return match
 			}
-			var buf bytes.Buffer
-			buf.WriteString("data:")
-			buf.WriteString(f.ContentType)
-			buf.WriteString(";base64,")
-			buf.WriteString(base64.StdEncoding.EncodeToString(f.Content))
-			return buf.Bytes()
+			if inlineBase64 || assets.shouldInline(rootedPath, f) {
+				var buf bytes.Buffer
+				buf.WriteString(prefix)
+				buf.WriteString("data:")
+				buf.WriteString(strings.Replace(f.ContentType, " ", "", -1))
+				buf.WriteString(";base64,")
+				buf.WriteString(base64.StdEncoding.EncodeToString(f.Content))
+				buf.WriteString(postfix)
+				return buf.Bytes()
+			}
 		}
 
 		// get the url from asset system
-		url, err := assets.GetUrl(rootedPath)
+		url, err := assets.getURL(rootedPath)
 		if err != nil {
 			replaceErr = err
 			return match
@@ -468,3 +1122,22 @@ func replaceProcessor(assets *Assets, path string, content []byte, regex *regexp
 
 	return newContent, nil
 }
+
+// inlineCandidate reports whether a reference to virtualPath may be inlined,
+// before the size of the asset is known.
+func (f *Assets) inlineCandidate(virtualPath string) bool {
+	return f.InlineMaxSize > 0 || f.matchesInlinePattern(virtualPath)
+}
+
+func (f *Assets) shouldInline(virtualPath string, file *File) bool {
+	return f.matchesInlinePattern(virtualPath) || (f.InlineMaxSize > 0 && len(file.Content) <= f.InlineMaxSize)
+}
+
+func (f *Assets) matchesInlinePattern(virtualPath string) bool {
+	for _, pattern := range f.InlinePatterns {
+		if matchGlob(pattern, virtualPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package web
+
+// OnProcessed registers hook to be called whenever a file has been loaded
+// and fully processed, with the virtual path it was loaded for. Use it to
+// record sizes, upload to a CDN or warn about oversized bundles. Hooks run on
+// the loading goroutine before Get returns, and must not Get the same path.
+func (f *Assets) OnProcessed(hook func(path string, file *File)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.processedHooks = append(f.processedHooks, hook)
+}
+
+func (f *Assets) runProcessedHooks(path string, file *File) {
+	f.lock.RLock()
+	hooks := f.processedHooks
+	f.lock.RUnlock()
+
+	for _, hook := range hooks {
+		hook(path, file)
+	}
+}
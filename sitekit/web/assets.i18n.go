@@ -0,0 +1,272 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// catalog holds the parsed messages of a translation file.
+type catalog struct {
+	file     *File
+	messages map[string]message
+}
+
+// message is a plain message, or the plural forms "zero", "one" and "other".
+type message struct {
+	text   string
+	plural map[string]string
+}
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &m.plural)
+}
+
+// AddTranslations loads message catalogs from the registered files
+// <directory>/<locale>.json, e.g. /i18n/en.json and /i18n/da-DK.json. A
+// catalog maps keys to fmt format strings, or to plural forms selected by
+// the first argument:
+//
+//	{"hello": "Hello, %v!", "items": {"zero": "No items", "one": "%d item", "other": "%d items"}}
+//
+// Templates translate with {{t "hello" .Name}} and {{t "items" .Count}}.
+// Catalogs are read again when their files change.
+func (f *Assets) AddTranslations(directory string) {
+	if !strings.HasSuffix(directory, "/") {
+		directory += "/"
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.translations = append(f.translations, directory)
+}
+
+// Locales returns the sorted locales of the translation catalogs.
+func (f *Assets) Locales() []string {
+	f.lock.RLock()
+	directories := f.translations
+	f.lock.RUnlock()
+
+	seen := make(map[string]bool)
+	locales := make([]string, 0)
+	for _, directory := range directories {
+		matches, _ := f.Glob(directory + "*.json")
+		for _, match := range matches {
+			locale := strings.TrimSuffix(path.Base(match), ".json")
+			if !seen[locale] {
+				seen[locale] = true
+				locales = append(locales, locale)
+			}
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// catalog returns the messages of locale, parsing its file when it has
+// changed since last time.
+func (f *Assets) catalog(locale string) (*catalog, error) {
+	f.lock.RLock()
+	directories := f.translations
+	var virtualPath string
+	for _, directory := range directories {
+		if f.lookup(directory+locale+".json") != nil {
+			virtualPath = directory + locale + ".json"
+			break
+		}
+	}
+	cached := f.catalogs[locale]
+	f.lock.RUnlock()
+	if virtualPath == "" {
+		return nil, nil
+	}
+
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.file == file {
+		return cached, nil
+	}
+
+	parsed := &catalog{file: file}
+	if err := json.Unmarshal(file.Content, &parsed.messages); err != nil {
+		return nil, fmt.Errorf("%v: %v", virtualPath, err)
+	}
+	f.lock.Lock()
+	f.catalogs[locale] = parsed
+	f.lock.Unlock()
+	return parsed, nil
+}
+
+// Translate returns the message for key in locale, formatted with args. The
+// message is looked up in locale, its language ("da" for "da-DK") and the
+// DefaultLocale, in that order; the key itself is returned if none has it.
+func (f *Assets) Translate(locale string, key string, args ...interface{}) string {
+	candidates := []string{locale}
+	if dash := strings.IndexAny(locale, "-_"); dash != -1 {
+		candidates = append(candidates, locale[:dash])
+	}
+	candidates = append(candidates, f.DefaultLocale)
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		messages, err := f.catalog(candidate)
+		if err != nil || messages == nil {
+			continue
+		}
+		if m, found := messages.messages[key]; found {
+			format := m.format(args)
+			if used := formatArgs(format); used < len(args) {
+				args = args[:used]
+			}
+			return fmt.Sprintf(format, args...)
+		}
+	}
+	return key
+}
+
+// format returns the format string of the message, choosing the plural form
+// by the first argument.
+func (m message) format(args []interface{}) string {
+	if m.plural == nil {
+		return m.text
+	}
+
+	form := "other"
+	if len(args) > 0 {
+		if n, err := toFloat(args[0]); err == nil {
+			switch n {
+			case 0:
+				if _, found := m.plural["zero"]; found {
+					form = "zero"
+				}
+			case 1:
+				form = "one"
+			}
+		}
+	}
+	if text, found := m.plural[form]; found {
+		return text
+	}
+	return m.plural["other"]
+}
+
+// formatArgs returns how many arguments format uses, so arguments only
+// used to pick a plural form don't show up as extra.
+func formatArgs(format string) int {
+	used, next := 0, 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		if i < len(format) && format[i] == '[' {
+			if end := strings.Index(format[i:], "]"); end != -1 {
+				if n, err := strconv.Atoi(format[i+1 : i+end]); err == nil {
+					next = n - 1
+				}
+			}
+		}
+		next++
+		if next > used {
+			used = next
+		}
+	}
+	return used
+}
+
+// MatchLocale picks the locale for a request: the lang query parameter, or
+// the best match of the Accept-Language header among the Locales, or else
+// the DefaultLocale.
+func (f *Assets) MatchLocale(r *http.Request) string {
+	locales := f.Locales()
+	if len(locales) == 0 {
+		return f.DefaultLocale
+	}
+	available := make(map[string]string)
+	for _, locale := range locales {
+		available[strings.ToLower(locale)] = locale
+	}
+	match := func(tag string) string {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if locale, found := available[tag]; found {
+			return locale
+		}
+		if dash := strings.IndexAny(tag, "-_"); dash != -1 {
+			return available[tag[:dash]]
+		}
+		return ""
+	}
+
+	if locale := match(r.URL.Query().Get("lang")); locale != "" {
+		return locale
+	}
+
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, quality := part, 1.0
+		if semicolon := strings.Index(part, ";"); semicolon != -1 {
+			tag = part[:semicolon]
+			if q := strings.TrimSpace(part[semicolon+1:]); strings.HasPrefix(q, "q=") {
+				quality, _ = strconv.ParseFloat(q[2:], 64)
+			}
+		}
+		tags = append(tags, weighted{tag, quality})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+	for _, tag := range tags {
+		if locale := match(tag.tag); locale != "" && tag.quality > 0 {
+			return locale
+		}
+	}
+	return f.DefaultLocale
+}
+
+// localizedFuncs returns the template funcs with t translating to locale.
+func (f *Assets) localizedFuncs(locale string) template.FuncMap {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	// a copy, as SetTemplateFunc may change the map during parsing
+	funcs := make(template.FuncMap, len(f.templateFuncMap))
+	for name, templateFunc := range f.templateFuncMap {
+		funcs[name] = templateFunc
+	}
+	if locale != "" {
+		funcs["t"] = func(key string, args ...interface{}) string {
+			return f.Translate(locale, key, args...)
+		}
+	}
+	return funcs
+}
+
+// RenderLocalizedTemplate is RenderTemplate with the t template func
+// translating to locale, e.g. from MatchLocale.
+func (f *Assets) RenderLocalizedTemplate(templatePathArr []string, locale string, w http.ResponseWriter, data interface{}) error {
+	return f.renderNamedTemplate(templatePathArr, templatePathArr[len(templatePathArr)-1], locale, w, data)
+}
+
+// RenderLocalizedTemplateString is RenderTemplateString with the t template
+// func translating to locale.
+func (f *Assets) RenderLocalizedTemplateString(templatePathArr []string, locale string, data interface{}) (string, error) {
+	return f.renderNamedTemplateString(templatePathArr, templatePathArr[len(templatePathArr)-1], locale, data)
+}
@@ -0,0 +1,331 @@
+package web
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"html"
+	"html/template"
+	"image/png"
+	"net/http"
+	"time"
+)
+
+// ImageOptimizeConfig limits the work spent optimizing images, so huge
+// images don't stall startup. Images over the limits are served as they are.
+type ImageOptimizeConfig struct {
+	MaxSize int           // bytes, 10MB by default
+	Timeout time.Duration // per image, 5 seconds by default
+}
+
+// AddImageOptimizePreprocessors losslessly optimizes .png, .jpg and .jpeg
+// files when they are loaded.
+func (f *Assets) AddImageOptimizePreprocessors(config ImageOptimizeConfig) {
+	processor := ImageOptimizePreprocessor(config)
+	for _, extension := range []string{".png", ".jpg", ".jpeg"} {
+		f.AddPreprocessorRule(PreprocessorRule{Extension: extension, Stage: StageMinify, Processor: processor})
+	}
+}
+
+// ImageOptimizePreprocessor returns a Preprocessor that re-encodes png images
+// with the best compression and strips metadata (comments, EXIF, XMP, IPTC)
+// from jpeg images without touching the image data. The color profile, the
+// png gamma and chromaticities and the EXIF orientation are kept; animated
+// pngs and pngs with other chunks re-encoding would lose are left alone.
+// Results that aren't smaller than the original are discarded.
+func ImageOptimizePreprocessor(config ImageOptimizeConfig) Preprocessor {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 10 << 20
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second * 5
+	}
+
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		if len(content) > config.MaxSize {
+			return content, nil
+		}
+
+		var optimize func([]byte) ([]byte, error)
+		switch {
+		case bytes.HasPrefix(content, []byte("\x89PNG\r\n\x1a\n")):
+			optimize = optimizePNG
+		case bytes.HasPrefix(content, []byte{0xff, 0xd8}):
+			optimize = stripJPEGMetadata
+		default:
+			return content, nil
+		}
+
+		done := make(chan []byte, 1)
+		go func() {
+			optimized, err := assets.cached("imageoptimize", content, func() ([]byte, error) {
+				return optimize(content)
+			})
+			if err != nil {
+				optimized = nil
+			}
+			done <- optimized
+		}()
+
+		select {
+		case optimized := <-done:
+			if optimized != nil && len(optimized) < len(content) {
+				return optimized, nil
+			}
+		case <-time.After(config.Timeout):
+		}
+		return content, nil
+	}
+}
+
+func optimizePNG(content []byte) ([]byte, error) {
+	colors, lossless := pngChunks(content)
+	if !lossless {
+		return content, nil
+	}
+	img, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	// image/png writes none of the color chunks, so they go after IHDR
+	encoded := buf.Bytes()
+	const headerEnd = 8 + 12 + 13
+	return append(append(encoded[:headerEnd:headerEnd], colors...), encoded[headerEnd:]...), nil
+}
+
+// pngChunks returns the chunks of a png affecting how its colors are
+// displayed: cHRM, gAMA, iCCP, sBIT and sRGB. lossless is false if the png
+// has chunks that re-encoding would lose, e.g. the frames of an animated png
+// or its EXIF orientation; text and time chunks are metadata and dropped.
+func pngChunks(content []byte) (colors []byte, lossless bool) {
+	for i := 8; i+12 <= len(content); {
+		length := binary.BigEndian.Uint32(content[i:])
+		if length > uint32(len(content)-i-12) {
+			return nil, false
+		}
+		end := i + 12 + int(length)
+		switch string(content[i+4 : i+8]) {
+		case "cHRM", "gAMA", "iCCP", "sBIT", "sRGB":
+			colors = append(colors, content[i:end]...)
+		case "IHDR", "PLTE", "IDAT", "tRNS", "tEXt", "zTXt", "iTXt", "tIME":
+		case "IEND":
+			return colors, true
+		default:
+			return nil, false
+		}
+		i = end
+	}
+	return nil, false
+}
+
+// stripJPEGMetadata drops the comment and application segments preceding the
+// image data, except JFIF (APP0), the ICC profile (APP2) and Adobe (APP14),
+// which affect how the image is decoded. EXIF (APP1) is replaced by a segment
+// holding only the orientation, so rotated photos stay upright.
+func stripJPEGMetadata(content []byte) ([]byte, error) {
+	errInvalid := errors.New("invalid jpeg")
+	result := []byte{0xff, 0xd8}
+	i := 2
+	for i+4 <= len(content) {
+		if content[i] != 0xff {
+			return nil, errInvalid
+		}
+		marker := content[i+1]
+		if marker == 0xff {
+			i++ // fill byte
+			continue
+		}
+
+		// the rest of the file from the start of scan is kept as it is
+		if marker == 0xda {
+			return append(result, content[i:]...), nil
+		}
+
+		length := int(content[i+2])<<8 | int(content[i+3])
+		end := i + 2 + length
+		if length < 2 || end > len(content) {
+			return nil, errInvalid
+		}
+
+		if marker == 0xe1 {
+			if orientation := exifOrientation(content[i+4 : end]); orientation > 1 {
+				result = append(result, exifOrientationSegment(orientation)...)
+			}
+			i = end
+			continue
+		}
+
+		isMetadata := marker == 0xfe || (marker >= 0xe1 && marker <= 0xef && marker != 0xe2 && marker != 0xee)
+		if !isMetadata {
+			result = append(result, content[i:end]...)
+		}
+		i = end
+	}
+	return nil, errInvalid
+}
+
+// exifOrientation returns the Orientation tag of an APP1 segment, or 0 if it
+// has none.
+func exifOrientation(segment []byte) uint16 {
+	if !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+		return 0
+	}
+	tiff := segment[6:]
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := order.Uint32(tiff[4:])
+	if offset < 8 || offset > uint32(len(tiff)-2) {
+		return 0
+	}
+	entries := tiff[offset+2:]
+	for n := int(order.Uint16(tiff[offset:])); n > 0 && len(entries) >= 12; n-- {
+		if order.Uint16(entries) == 0x0112 && order.Uint16(entries[2:]) == 3 {
+			return order.Uint16(entries[8:])
+		}
+		entries = entries[12:]
+	}
+	return 0
+}
+
+// exifOrientationSegment returns an APP1 segment with an EXIF header holding
+// only the Orientation tag.
+func exifOrientationSegment(orientation uint16) []byte {
+	return []byte{
+		0xff, 0xe1, 0x00, 0x22, 'E', 'x', 'i', 'f', 0, 0,
+		'I', 'I', 0x2a, 0, 8, 0, 0, 0, // tiff header, IFD0 at 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), byte(orientation >> 8), 0, 0, // Orientation, SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+}
+
+// ImageVariantConfig configures the commands generating modern image formats.
+// Each command reads the original image on stdin and writes the variant to
+// stdout; a nil command disables the format.
+type ImageVariantConfig struct {
+	WebP    []string // e.g. {"cwebp", "-quiet", "-o", "-", "--", "-"}
+	AVIF    []string // e.g. a script wrapping avifenc
+	Timeout time.Duration
+}
+
+// imageVariants lists the variant formats in order of preference.
+var imageVariants = []struct {
+	extension   string
+	contentType string
+}{
+	{".avif", "image/avif"},
+	{".webp", "image/webp"},
+}
+
+// AddImageVariants generates WebP and AVIF variants of .png, .jpg and .jpeg
+// files when they are loaded, registered at the virtual path of the image
+// plus ".webp" or ".avif". Variants that aren't smaller than the image are
+// dropped. Requests for the image get the best variant their Accept header
+// allows, and the picture template func lists them for the browser to pick.
+func (f *Assets) AddImageVariants(config ImageVariantConfig) {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultExecTimeout
+	}
+	commands := map[string][]string{".webp": config.WebP, ".avif": config.AVIF}
+
+	processor := func(assets *Assets, path string, content []byte) ([]byte, error) {
+		h := assets.HashFunc()
+		h.Write(content)
+		source := hex.EncodeToString(h.Sum(nil))
+		for _, variant := range imageVariants {
+			command := commands[variant.extension]
+			if len(command) == 0 {
+				continue
+			}
+			converted, err := assets.runCommandCached(config.Timeout, content, command[0], expandArgs(command[1:], path)...)
+			if err != nil {
+				return nil, err
+			}
+			if len(converted) > 0 && len(converted) < len(content) {
+				assets.AddContent(path+variant.extension, converted, FileOptions{ContentType: variant.contentType})
+				assets.lock.Lock()
+				assets.variantSources[path+variant.extension] = source
+				assets.lock.Unlock()
+			}
+		}
+		return content, nil
+	}
+	for _, extension := range []string{".png", ".jpg", ".jpeg"} {
+		f.AddPreprocessorRule(PreprocessorRule{Extension: extension, Stage: StageMinify + 10, Processor: processor})
+	}
+}
+
+// imageVariant picks the variant of file to serve for r: the one with the
+// highest quality value the Accept header lists by name, ties going to the
+// preferred format. hasVariants reports whether the response depends on the
+// Accept header at all.
+func (f *Assets) imageVariant(r *http.Request, file *File) (variant *File, hasVariants bool) {
+	qualities := parseQualities(r.Header.Get("Accept"))
+	bestQ := 0.0
+	for _, candidate := range imageVariants {
+		variantPath := file.virtualPath + candidate.extension
+		if !f.hasImageVariant(file, variantPath) {
+			continue
+		}
+
+		hasVariants = true
+		if q := qualities[candidate.contentType]; q > bestQ {
+			if loaded, err := f.Get(variantPath); err == nil {
+				variant, bestQ = loaded, q
+			}
+		}
+	}
+	return variant, hasVariants
+}
+
+// hasImageVariant reports whether variantPath is a variant of file, rather
+// than of another version of the image, e.g. when an old url is requested
+// after the image changed.
+func (f *Assets) hasImageVariant(file *File, variantPath string) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.lookup(variantPath) != nil && f.variantSources[variantPath] == file.HashString
+}
+
+// PictureTag returns a <picture> element for the image at virtualPath, with a
+// <source> for each of its generated variants.
+func (f *Assets) PictureTag(virtualPath string, alt string) (template.HTML, error) {
+	url, file, err := f.urlAndFile(virtualPath)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<picture>")
+	for _, candidate := range imageVariants {
+		if !f.hasImageVariant(file, file.virtualPath+candidate.extension) {
+			continue
+		}
+		variantURL, err := f.GetUrl(file.virtualPath + candidate.extension)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(`<source type="` + candidate.contentType + `" srcset="` + html.EscapeString(variantURL) + `">`)
+	}
+	buf.WriteString(`<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(alt) + `"></picture>`)
+	return template.HTML(buf.String()), nil
+}
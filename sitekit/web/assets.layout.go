@@ -0,0 +1,44 @@
+package web
+
+import (
+	"net/http"
+)
+
+// ExtendLayout makes layout a child of parent: rendering with layout renders
+// parent, with the blocks defined by both the page and layout. Blocks defined
+// by the page win over those of layout, which win over those of parent.
+func (f *Assets) ExtendLayout(layout string, parent string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.layouts[layout] = parent
+}
+
+// layoutChain returns the template paths for rendering page with layout:
+// the page, the layout and the layouts it extends, outermost last.
+func (f *Assets) layoutChain(layout string, page string) []string {
+	templatePathArr := []string{page}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	seen := map[string]bool{page: true}
+	for layout != "" && !seen[layout] {
+		seen[layout] = true
+		templatePathArr = append(templatePathArr, layout)
+		layout = f.layouts[layout]
+	}
+	return templatePathArr
+}
+
+// RenderWithLayout renders page inside layout, an empty layout rendering just
+// the page. The page defines the blocks the layout shows, e.g. with
+// {{define "content"}}...{{end}} in the page and {{block "content" .}}
+// default content{{end}} in the layout.
+func (f *Assets) RenderWithLayout(layout string, page string, w http.ResponseWriter, data interface{}) error {
+	return f.RenderTemplate(f.layoutChain(layout, page), w, data)
+}
+
+// RenderWithLayoutString is RenderWithLayout returning the output.
+func (f *Assets) RenderWithLayoutString(layout string, page string, data interface{}) (string, error) {
+	return f.RenderTemplateString(f.layoutChain(layout, page), data)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// liveReloadPath is where Serve streams changes in ModeDevelopment, after the
+// base url.
+const liveReloadPath = "_livereload"
+
+// liveReloadDelay is how long changes are collected before they are sent.
+const liveReloadDelay = 50 * time.Millisecond
+
+// liveReloadScript is the livereload template func: in ModeDevelopment a
+// script reloading the page when Watch sees a file change, nothing otherwise.
+func (f *Assets) liveReloadScript() template.HTML {
+	if f.currentMode() != ModeDevelopment {
+		return ""
+	}
+	return template.HTML(`<script>new EventSource("` + template.JSEscapeString(f.baseURL+liveReloadPath) + `").onmessage=function(){location.reload()}</script>`)
+}
+
+// serveLiveReload streams the virtual paths of changed files as server-sent
+// events until the client goes away.
+func (f *Assets) serveLiveReload(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	changes := make(chan string, 16)
+	f.lock.Lock()
+	f.changeListeners[changes] = true
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		delete(f.changeListeners, changes)
+		f.lock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case virtualPath := <-changes:
+			// saving a file takes a few writes, so changes are sent in batches
+			changed := []string{virtualPath}
+			timeout := time.After(liveReloadDelay)
+		batch:
+			for {
+				select {
+				case virtualPath := <-changes:
+					changed = appendUnique(changed, virtualPath)
+				case <-timeout:
+					break batch
+				}
+			}
+			for _, virtualPath := range changed {
+				fmt.Fprintf(w, "data: %v\n\n", virtualPath)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// notifyChanged tells the live reload clients that virtualPath changed.
+// Clients that are behind miss it rather than holding up the watcher.
+func (f *Assets) notifyChanged(virtualPath string) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	for changes := range f.changeListeners {
+		select {
+		case changes <- virtualPath:
+		default:
+		}
+	}
+}
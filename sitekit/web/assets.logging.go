@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// SetLogOutput sends structured events about asset loads, preprocessor
+// failures, cache invalidations, template parses, serve errors, watcher and
+// size budget warnings and the logging of preprocessors to output, as a
+// "web.assets" logkit operation. A nil output, the default, logs
+// nothing.
+func (f *Assets) SetLogOutput(output logkit.Output) {
+	if output == nil {
+		f.logContext.Store((*logkit.Context)(nil))
+		return
+	}
+	ctx, _ := logkit.OperationWithOutput(context.Background(), "web.assets", output)
+	f.logContext.Store(ctx)
+}
+
+// logger returns the operation to log to, or nil. It doesn't take the lock,
+// so it can be used while holding it.
+func (f *Assets) logger() *logkit.Context {
+	ctx, _ := f.logContext.Load().(*logkit.Context)
+	return ctx
+}
+
+// withLogOperation returns ctx carrying the SetLogOutput operation, if one is
+// set, for preprocessors to log to.
+func (f *Assets) withLogOperation(ctx context.Context) context.Context {
+	if operation := f.logger(); operation != nil {
+		return operationContext{Context: ctx, operation: operation}
+	}
+	return ctx
+}
+
+// operationContext is ctx with the logkit operation replaced, keeping its
+// deadline, cancellation and other values, such as pprof labels.
+type operationContext struct {
+	context.Context
+	operation *logkit.Context
+}
+
+func (c operationContext) Value(key interface{}) interface{} {
+	if operation, ok := c.operation.Value(key).(*logkit.Context); ok {
+		return operation
+	}
+	return c.Context.Value(key)
+}
+
+func (f *Assets) logDebug(msg string, fields ...logkit.Field) {
+	if ctx := f.logger(); ctx != nil {
+		ctx.Debug(msg, fields...)
+	}
+}
+
+func (f *Assets) logWarn(msg string, fields ...logkit.Field) {
+	if ctx := f.logger(); ctx != nil {
+		ctx.Warn(msg, fields...)
+	}
+}
+
+func (f *Assets) logError(msg string, fields ...logkit.Field) {
+	if ctx := f.logger(); ctx != nil {
+		ctx.Error(msg, fields...)
+	}
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+)
+
+// Manifest maps virtual paths to their processed, fingerprinted assets. It
+// lets other processes resolve asset urls without the asset files.
+type Manifest map[string]ManifestEntry
+
+type ManifestEntry struct {
+	URL         string `json:"url"`
+	Hash        string `json:"hash"`
+	Integrity   string `json:"integrity"`
+	Size        int    `json:"size"`
+	ContentType string `json:"contentType"`
+}
+
+// BuildManifest processes every registered file and returns the manifest.
+func (f *Assets) BuildManifest() (Manifest, error) {
+	manifest := make(Manifest)
+	err := f.Walk(func(virtualPath string, file *File) error {
+		manifest[virtualPath] = ManifestEntry{
+			URL:         f.fileURL(file),
+			Hash:        file.HashString,
+			Integrity:   file.Integrity,
+			Size:        len(file.Content),
+			ContentType: file.ContentType,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Manifest returns the manifest of all registered files as JSON.
+func (f *Assets) Manifest() ([]byte, error) {
+	manifest, err := f.BuildManifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// ParseManifest reads a manifest generated by Assets.Manifest.
+func ParseManifest(data []byte) (Manifest, error) {
+	manifest := make(Manifest)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// URL returns the fingerprinted url of virtualPath.
+func (m Manifest) URL(virtualPath string) (string, error) {
+	entry, found := m[virtualPath]
+	if !found {
+		return "", errors.New("File Not Found: " + virtualPath)
+	}
+	return entry.URL, nil
+}
+
+// LoadManifest makes GetUrl (and the asset template func) resolve paths that
+// aren't registered from manifest, e.g. when the assets are served elsewhere.
+func (f *Assets) LoadManifest(manifest Manifest) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.manifest = manifest
+	atomic.AddInt64(&f.version, 1)
+}
+
+// BundleManifest maps the virtual paths of bundles to their urls and what
+// they are built from.
+type BundleManifest map[string]BundleManifestEntry
+
+type BundleManifestEntry struct {
+	URL          string   `json:"url"`
+	Integrity    string   `json:"integrity"`
+	Chunks       []string `json:"chunks,omitempty"` // urls of the chunks loaded on demand
+	Dependencies []string `json:"dependencies"`     // virtual paths the bundle is built from
+}
+
+// GetBundle builds the bundle registered at virtualPath with Bundle or
+// ModuleBundle and returns its manifest entry.
+func (f *Assets) GetBundle(virtualPath string) (BundleManifestEntry, error) {
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		return BundleManifestEntry{}, err
+	}
+
+	f.lock.RLock()
+	_, isBundle := f.bundles[virtualPath]
+	chunks, isModuleBundle := f.chunks[virtualPath]
+	f.lock.RUnlock()
+	if !isBundle && !isModuleBundle {
+		return BundleManifestEntry{}, errors.New("not a bundle: " + virtualPath)
+	}
+
+	entry := BundleManifestEntry{
+		URL:          f.publicize(f.fileURL(file)),
+		Integrity:    file.Integrity,
+		Dependencies: f.Sources(virtualPath),
+	}
+	for _, chunk := range chunks {
+		url, err := f.GetUrl(chunk)
+		if err != nil {
+			return BundleManifestEntry{}, err
+		}
+		entry.Chunks = append(entry.Chunks, url)
+	}
+	return entry, nil
+}
+
+// BuildBundleManifest builds every bundle and returns the bundle manifest.
+func (f *Assets) BuildBundleManifest() (BundleManifest, error) {
+	f.lock.RLock()
+	var bundles []string
+	for virtualPath := range f.bundles {
+		bundles = append(bundles, virtualPath)
+	}
+	for virtualPath := range f.chunks {
+		bundles = append(bundles, virtualPath)
+	}
+	f.lock.RUnlock()
+
+	manifest := make(BundleManifest)
+	for _, virtualPath := range bundles {
+		entry, err := f.GetBundle(virtualPath)
+		if err != nil {
+			return nil, err
+		}
+		manifest[virtualPath] = entry
+	}
+	return manifest, nil
+}
+
+// BundleManifest returns the bundle manifest as JSON.
+func (f *Assets) BundleManifest() ([]byte, error) {
+	manifest, err := f.BuildBundleManifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(manifest, "", "  ")
+}
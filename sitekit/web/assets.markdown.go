@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+// AddMarkdownPreprocessor renders .md files to html, so content pages can
+// live in the asset tree. Frontmatter is available through Frontmatter and
+// the frontmatter template func; the markdown template func embeds the
+// rendered page in a layout.
+func (f *Assets) AddMarkdownPreprocessor() {
+	f.SetContentType(".md", "text/html; charset=utf-8")
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".md", Stage: StageCompile, Processor: MarkdownPreprocessor})
+}
+
+// MarkdownPreprocessor strips the frontmatter of a markdown file, records it
+// for the file and renders the rest to html.
+func MarkdownPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
+	frontmatter, body, err := parseFrontmatter(content)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	assets.lock.Lock()
+	assets.frontmatter[path] = frontmatter
+	assets.lock.Unlock()
+
+	var buf bytes.Buffer
+	if err := goldmark.Convert(body, &buf); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseFrontmatter splits a leading block of "key: value" lines between
+// "---" lines from the content.
+func parseFrontmatter(content []byte) (map[string]string, []byte, error) {
+	frontmatter := make(map[string]string)
+	normalized := bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+	if !bytes.HasPrefix(normalized, []byte("---\n")) {
+		return frontmatter, content, nil
+	}
+
+	end := bytes.Index(normalized[4:], []byte("\n---"))
+	if end == -1 {
+		return nil, nil, fmt.Errorf("unterminated frontmatter")
+	}
+	block, body := normalized[4:4+end], normalized[4+end+4:]
+	body = bytes.TrimPrefix(body, []byte("\n"))
+
+	for i, line := range strings.Split(string(block), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			return nil, nil, fmt.Errorf("frontmatter line %v: expected key: value", i+2)
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		frontmatter[strings.TrimSpace(line[:colon])] = value
+	}
+	return frontmatter, body, nil
+}
+
+// Frontmatter returns the frontmatter of the markdown file at virtualPath.
+func (f *Assets) Frontmatter(virtualPath string) (map[string]string, error) {
+	if _, err := f.Get(virtualPath); err != nil {
+		return nil, err
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if entry := f.lookup(virtualPath); entry != nil {
+		virtualPath = entry.virtualPath
+	}
+	return f.frontmatter[virtualPath], nil
+}
+
+// Markdown returns the rendered html of the markdown file at virtualPath.
+func (f *Assets) Markdown(virtualPath string) (template.HTML, error) {
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(file.Content), nil
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+
+	"github.com/tdewolff/minify"
+	"github.com/tdewolff/minify/css"
+	"github.com/tdewolff/minify/html"
+)
+
+var cssMinifier = minify.New()
+
+func init() {
+	cssMinifier.Add("text/css", &css.Minifier{Decimals: -1})
+}
+
+// CSSMinifyPreprocessor minifies stylesheets. NewAssets registers it for
+// .css and .scss files in ModeProduction.
+func CSSMinifyPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
+	return cssMinifier.Bytes("text/css", content)
+}
+
+// HTMLMinifyOptions controls HTML minification. Whitespace is collapsed and
+// comments are removed unless kept.
+type HTMLMinifyOptions struct {
+	KeepWhitespace          bool
+	KeepConditionalComments bool
+}
+
+func (o HTMLMinifyOptions) minify(content []byte) ([]byte, error) {
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{
+		KeepDefaultAttrVals:     true,
+		KeepDocumentTags:        true,
+		KeepEndTags:             true,
+		KeepWhitespace:          o.KeepWhitespace,
+		KeepConditionalComments: o.KeepConditionalComments,
+	})
+	return m.Bytes("text/html", content)
+}
+
+// HTMLMinifyPreprocessor returns a Preprocessor that minifies html.
+func HTMLMinifyPreprocessor(options HTMLMinifyOptions) Preprocessor {
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return options.minify(content)
+	}
+}
+
+// AddHTMLMinifyPreprocessor minifies .html and .htm assets.
+func (f *Assets) AddHTMLMinifyPreprocessor(options HTMLMinifyOptions) {
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".htm", Stage: StageMinify, Processor: HTMLMinifyPreprocessor(options)})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".html", Stage: StageMinify, Processor: HTMLMinifyPreprocessor(options)})
+}
+
+// SetTemplateMinify minifies the rendered output of the template at
+// virtualPath, the last of the paths passed to RenderTemplate. A nil options
+// turns minification off for the template, also when MinifyTemplates is set.
+func (f *Assets) SetTemplateMinify(virtualPath string, options *HTMLMinifyOptions) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.templateMinify[virtualPath] = options
+}
+
+// templateMinifyOptions returns how the output of templatePathArr is
+// minified, or nil to leave it alone.
+func (f *Assets) templateMinifyOptions(templatePathArr []string) *HTMLMinifyOptions {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if options, found := f.templateMinify[templatePathArr[len(templatePathArr)-1]]; found {
+		return options
+	}
+	return f.MinifyTemplates
+}
+
+// executeTemplate renders name to w, filling in placeholders and minifying
+// the output when configured for templatePathArr.
+func (f *Assets) executeTemplate(t *template.Template, templatePathArr []string, name string, w io.Writer, data interface{}) error {
+	options := f.templateMinifyOptions(templatePathArr)
+	meta := f.getTemplateMeta(templatePathArr)
+	if options == nil && !meta.placeholders {
+		return t.ExecuteTemplate(w, name, data)
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	output := buf.Bytes()
+	var err error
+	if meta.placeholders {
+		if output, err = f.inlineCritical(output); err != nil {
+			return err
+		}
+		if output, err = f.fillPreloadLinks(output, meta); err != nil {
+			return err
+		}
+	}
+	if options != nil {
+		if output, err = options.minify(output); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(output)
+	return err
+}
@@ -0,0 +1,84 @@
+package web
+
+import (
+	"sync/atomic"
+)
+
+// Mode selects between development and production behavior.
+type Mode int
+
+const (
+	// ModeDefault runs neither development nor production only
+	// preprocessors, and serves assets like ModeProduction.
+	ModeDefault Mode = iota
+
+	// ModeDevelopment runs DevelopmentOnly preprocessors, reloads files and
+	// templates changed on disk and serves assets with Cache-Control:
+	// no-cache, so edits show up on reload.
+	ModeDevelopment
+
+	// ModeProduction runs ProductionOnly preprocessors, like css minification.
+	ModeProduction
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeDevelopment:
+		return "development"
+	case ModeProduction:
+		return "production"
+	}
+	return "default"
+}
+
+// ProductionOnly wraps processor so it only runs in ModeProduction, leaving
+// content untouched otherwise.
+func ProductionOnly(processor Preprocessor) Preprocessor {
+	return modeOnly(ModeProduction, processor)
+}
+
+// DevelopmentOnly wraps processor so it only runs in ModeDevelopment, e.g. to
+// add debug banners.
+func DevelopmentOnly(processor Preprocessor) Preprocessor {
+	return modeOnly(ModeDevelopment, processor)
+}
+
+func modeOnly(mode Mode, processor Preprocessor) Preprocessor {
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		if assets.currentMode() != mode {
+			return content, nil
+		}
+		return processor(assets, path, content)
+	}
+}
+
+// SetDevMode switches a running site to ModeDevelopment, or to
+// ModeProduction if dev is false, without a restart: every file is processed
+// again in the new mode, so e.g. css is no longer minified, the template
+// caches are dropped, and from then on changed files and templates are
+// reloaded, assets are served with Cache-Control: no-cache and errors are
+// shown with DevErrorRenderer. The Mode field keeps the configured mode.
+func (f *Assets) SetDevMode(dev bool) {
+	mode := ModeProduction
+	if dev {
+		mode = ModeDevelopment
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	atomic.StoreInt32(&f.modeOverride, int32(mode)+1)
+	for virtualPath, entry := range f.entries {
+		f.entries[virtualPath] = entry.reset()
+	}
+	f.resetTemplates()
+	atomic.AddInt64(&f.version, 1)
+}
+
+// currentMode returns the mode set with SetDevMode, or else Mode.
+func (f *Assets) currentMode() Mode {
+	if override := atomic.LoadInt32(&f.modeOverride); override != 0 {
+		return Mode(override - 1)
+	}
+	return f.Mode
+}
@@ -0,0 +1,409 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	moduleImportRegexp        = regexp.MustCompile(`(?m)^[ \t]*import\s*([\w$*{}\s,]+?)\s*from\s*["']([^"'\n]+)["'][ \t]*;?`)
+	moduleSideEffectRegexp    = regexp.MustCompile(`(?m)^[ \t]*import\s*["']([^"'\n]+)["'][ \t]*;?`)
+	moduleExportFromRegexp    = regexp.MustCompile(`(?m)^[ \t]*export\s*(\*(?:\s*as\s+[\w$]+)?|\{[^}]*\})\s*from\s*["']([^"'\n]+)["'][ \t]*;?`)
+	moduleExportListRegexp    = regexp.MustCompile(`(?m)^[ \t]*export\s*\{([^}]*)\}[ \t]*;?`)
+	moduleExportDeclRegexp    = regexp.MustCompile(`(?m)^([ \t]*)export\s+(default\s+)?((?:async\s+)?function\s*\*?\s*([\w$]+)|class\s+([\w$]+)|(?:const|let|var)\s+([\w$]+))`)
+	moduleExportDefaultRegexp = regexp.MustCompile(`(?m)^([ \t]*)export\s+default\s+`)
+	moduleDynamicImportRegexp = regexp.MustCompile(`\bimport\(\s*["']([^"'\n]+)["']\s*\)`)
+)
+
+const moduleRuntime = `var __defs = {}, __cache = {};
+function __import(id) {
+	if (!__cache[id]) {
+		__cache[id] = {};
+		__defs[id](__cache[id]);
+	}
+	return __cache[id];
+}
+function __export(exports, getters) {
+	for (var name in getters) Object.defineProperty(exports, name, { enumerable: true, get: getters[name] });
+}
+function __exportAll(exports, module) {
+	Object.keys(module).forEach(function (name) {
+		if (name !== "default" && !(name in exports)) Object.defineProperty(exports, name, { enumerable: true, get: function () { return module[name]; } });
+	});
+}
+`
+
+// ModuleBundleOptions configures ModuleBundle.
+type ModuleBundleOptions struct {
+	// ChunkDynamicImports puts the modules loaded with import("./x.js") in
+	// a separate file, loaded when the import runs, instead of in the bundle.
+	ChunkDynamicImports bool
+}
+
+// ModuleBundle registers virtualPath as a single script containing the
+// module entry and everything it imports, so simple sites need no external
+// bundler. Imports must be relative or rooted paths of registered files
+// (".js" and "/index.js" may be left out), and are bound when the importing
+// module starts rather than live. Module sources are read unprocessed; the
+// bundle as a whole runs through the .js preprocessors. Chunks for dynamic
+// imports are registered below the bundle path, e.g. /bundles/app/js/lazy.js
+// for /bundles/app.js, and modules they share with the bundle are duplicated.
+// Chunks are found when ModuleBundle is called, so register the modules
+// first; dynamic imports added later are bundled inline until it is called
+// again.
+func (f *Assets) ModuleBundle(virtualPath string, entry string, options ModuleBundleOptions) {
+	var chunks []moduleChunk
+	if options.ChunkDynamicImports {
+		// errors surface when the bundle is generated
+		targets, _ := f.dynamicImports(entry)
+		for _, target := range targets {
+			chunk := moduleChunk{target: target, virtualPath: strings.TrimSuffix(virtualPath, path.Ext(virtualPath)) + strings.TrimSuffix(target, path.Ext(target)) + ".js"}
+			f.registerModuleBundle(chunk.virtualPath, target, nil, true)
+			chunks = append(chunks, chunk)
+		}
+	}
+	f.registerModuleBundle(virtualPath, entry, chunks, false)
+}
+
+// moduleChunk is a module loaded with import() that a bundle serves as a
+// separate script at virtualPath.
+type moduleChunk struct {
+	target      string
+	virtualPath string
+}
+
+func (f *Assets) registerModuleBundle(virtualPath string, entry string, chunks []moduleChunk, chunk bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.aliases, virtualPath)
+	delete(f.bundles, virtualPath)
+	delete(f.chunks, virtualPath)
+	if !chunk {
+		var paths []string
+		for _, c := range chunks {
+			paths = append(paths, c.virtualPath)
+		}
+		f.chunks[virtualPath] = paths
+	}
+	f.entries[virtualPath] = &assetEntry{
+		virtualPath: virtualPath,
+		generate: func() ([]byte, error) {
+			return f.bundleModules(virtualPath, entry, chunks, chunk)
+		},
+	}
+	f.invalidateDependents(virtualPath, make(map[string]bool))
+	atomic.AddInt64(&f.version, 1)
+}
+
+// dynamicImports returns the modules loaded with import() by entry and the
+// modules it imports statically.
+func (f *Assets) dynamicImports(entry string) ([]string, error) {
+	var targets []string
+	visited := make(map[string]bool)
+	found := make(map[string]bool)
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+
+		source, err := f.readSource(id)
+		if err != nil {
+			return err
+		}
+		resolve := func(specifier string) (string, error) {
+			return f.resolveModule(id, specifier)
+		}
+		_, dependencies, err := transformModule(id, source, resolve, func(target string) (string, error) {
+			if !found[target] {
+				found[target] = true
+				targets = append(targets, target)
+			}
+			return "", nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return targets, visit(entry)
+}
+
+func (f *Assets) bundleModules(virtualPath string, entry string, chunks []moduleChunk, chunk bool) ([]byte, error) {
+	var order []string
+	definitions := make(map[string][]byte)
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		if _, found := definitions[id]; found {
+			return nil
+		}
+		definitions[id] = nil
+
+		source, err := f.readSource(id)
+		if err != nil {
+			return err
+		}
+		resolve := func(specifier string) (string, error) {
+			return f.resolveModule(id, specifier)
+		}
+		dynamicImport := func(target string) (string, error) {
+			for _, c := range chunks {
+				if c.target != target {
+					continue
+				}
+				url, err := f.getURL(c.virtualPath)
+				if err != nil {
+					return "", err
+				}
+				return "import(" + strconv.Quote(url) + ").then(function (m) { return m.namespace; })", nil
+			}
+			if err := visit(target); err != nil {
+				return "", err
+			}
+			return "Promise.resolve().then(function () { return __import(" + strconv.Quote(target) + "); })", nil
+		}
+
+		definition, dependencies, err := transformModule(id, source, resolve, dynamicImport)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		definitions[id] = definition
+		order = append(order, id)
+		return nil
+	}
+
+	if err := visit(entry); err != nil {
+		return nil, err
+	}
+	f.setSources(virtualPath, order)
+
+	var buf bytes.Buffer
+	if !chunk {
+		buf.WriteString("(function () {\n")
+	}
+	buf.WriteString(moduleRuntime)
+	for _, id := range order {
+		buf.Write(definitions[id])
+	}
+	if chunk {
+		buf.WriteString("export const namespace = __import(" + strconv.Quote(entry) + ");\n")
+	} else {
+		buf.WriteString("__import(" + strconv.Quote(entry) + ");\n})();\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// resolveModule finds the registered file an import specifier in from refers
+// to.
+func (f *Assets) resolveModule(from string, specifier string) (string, error) {
+	if !isLocalReference(specifier, false) {
+		return "", fmt.Errorf("%v: cannot bundle bare import %q", from, specifier)
+	}
+	rooted, err := f.getRooted(from, specifier)
+	if err != nil {
+		return "", err
+	}
+	rooted = path.Clean(rooted)
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, candidate := range []string{rooted, rooted + ".js", rooted + "/index.js"} {
+		if f.lookup(candidate) != nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%v: cannot resolve import %q", from, specifier)
+}
+
+// transformModule rewrites the imports and exports of a module to the bundle
+// runtime and returns its definition with the modules it imports statically.
+// dynamicImport returns the expression replacing import() of a module.
+func transformModule(id string, source []byte, resolve func(string) (string, error), dynamicImport func(string) (string, error)) ([]byte, []string, error) {
+	var dependencies []string
+	var getters []string
+	var err error
+	counter := 0
+
+	importModule := func(specifier string) (string, string) {
+		resolved, resolveErr := resolve(specifier)
+		if resolveErr != nil {
+			err = resolveErr
+			return "", ""
+		}
+		dependencies = append(dependencies, resolved)
+		counter++
+		name := "__m" + strconv.Itoa(counter)
+		return name, "var " + name + " = __import(" + strconv.Quote(resolved) + ");"
+	}
+	replace := func(regex *regexp.Regexp, content []byte, replacer func(match [][]byte) string) []byte {
+		return regex.ReplaceAllFunc(content, func(statement []byte) []byte {
+			if err != nil {
+				return statement
+			}
+			return []byte(replacer(regex.FindSubmatch(statement)))
+		})
+	}
+
+	// export * from "./a.js", export { a, b as c } from "./a.js"
+	source = replace(moduleExportFromRegexp, source, func(match [][]byte) string {
+		name, statement := importModule(string(match[2]))
+		clause := strings.TrimSpace(string(match[1]))
+		switch {
+		case clause == "*":
+			return statement + " __exportAll(__exports, " + name + ");"
+		case strings.HasPrefix(clause, "*"):
+			alias := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(clause[1:]), "as"))
+			getters = append(getters, getter(alias, name))
+		default:
+			for _, binding := range splitBindings(clause) {
+				getters = append(getters, getter(binding[1], name+"."+binding[0]))
+			}
+		}
+		return statement
+	})
+
+	// import a, { b, c as d } from "./a.js", import * as ns from "./a.js"
+	source = replace(moduleImportRegexp, source, func(match [][]byte) string {
+		name, statement := importModule(string(match[2]))
+		var declarations []string
+		clause := strings.TrimSpace(string(match[1]))
+		for clause != "" {
+			var part string
+			if strings.HasPrefix(clause, "{") {
+				end := strings.Index(clause, "}")
+				if end == -1 {
+					err = fmt.Errorf("%v: invalid import %q", id, match[0])
+					return ""
+				}
+				part, clause = clause[:end+1], clause[end+1:]
+			} else if comma := strings.Index(clause, ","); comma != -1 {
+				part, clause = clause[:comma], clause[comma:]
+			} else {
+				part, clause = clause, ""
+			}
+			clause = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(clause), ","))
+			part = strings.TrimSpace(part)
+
+			switch {
+			case strings.HasPrefix(part, "{"):
+				var destructured []string
+				for _, binding := range splitBindings(part) {
+					destructured = append(destructured, binding[0]+": "+binding[1])
+				}
+				declarations = append(declarations, "var {"+strings.Join(destructured, ", ")+"} = "+name+";")
+			case strings.HasPrefix(part, "*"):
+				alias := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(part[1:]), "as"))
+				declarations = append(declarations, "var "+alias+" = "+name+";")
+			case part != "":
+				declarations = append(declarations, "var "+part+" = "+name+".default;")
+			}
+		}
+		return statement + " " + strings.Join(declarations, " ")
+	})
+
+	// import "./a.js"
+	source = replace(moduleSideEffectRegexp, source, func(match [][]byte) string {
+		_, statement := importModule(string(match[1]))
+		return statement
+	})
+
+	// export { a, b as c }
+	source = replace(moduleExportListRegexp, source, func(match [][]byte) string {
+		for _, binding := range splitBindings(string(match[1])) {
+			getters = append(getters, getter(binding[1], binding[0]))
+		}
+		return ""
+	})
+
+	// export function a() {}, export class B {}, export const c = 1,
+	// export default function d() {}
+	source = replace(moduleExportDeclRegexp, source, func(match [][]byte) string {
+		name := string(match[4]) + string(match[5]) + string(match[6])
+		exported := name
+		if len(match[2]) > 0 {
+			exported = "default"
+		}
+		getters = append(getters, getter(exported, name))
+		return string(match[1]) + string(match[3])
+	})
+
+	// export default expression
+	defaults := 0
+	source = replace(moduleExportDefaultRegexp, source, func(match [][]byte) string {
+		defaults++
+		getters = append(getters, getter("default", "__default"))
+		return string(match[1]) + "var __default = "
+	})
+	if err == nil && defaults > 1 {
+		err = fmt.Errorf("%v: more than one default export", id)
+	}
+
+	// import("./a.js")
+	source = replace(moduleDynamicImportRegexp, source, func(match [][]byte) string {
+		if !isLocalReference(string(match[1]), false) {
+			return string(match[0])
+		}
+		resolved, resolveErr := resolve(string(match[1]))
+		if resolveErr != nil {
+			err = resolveErr
+			return ""
+		}
+		expression, dynamicErr := dynamicImport(resolved)
+		if dynamicErr != nil {
+			err = dynamicErr
+		}
+		return expression
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("__defs[" + strconv.Quote(id) + "] = function (__exports) {\n")
+	if len(getters) > 0 {
+		buf.WriteString("__export(__exports, {" + strings.Join(getters, ", ") + "});\n")
+	}
+	buf.Write(bytes.TrimRight(source, "\n"))
+	buf.WriteString("\n};\n")
+	return buf.Bytes(), dependencies, nil
+}
+
+// splitBindings parses "{ a, b as c }" into [local/imported, exported/alias]
+// pairs: [a a] [b c].
+func splitBindings(clause string) [][2]string {
+	var bindings [][2]string
+	for _, binding := range strings.Split(strings.Trim(strings.TrimSpace(clause), "{}"), ",") {
+		fields := strings.Fields(binding)
+		switch {
+		case len(fields) == 1:
+			bindings = append(bindings, [2]string{fields[0], fields[0]})
+		case len(fields) == 3 && fields[1] == "as":
+			bindings = append(bindings, [2]string{fields[0], fields[2]})
+		}
+	}
+	return bindings
+}
+
+func getter(name string, expression string) string {
+	return strconv.Quote(name) + ": function () { return " + expression + "; }"
+}
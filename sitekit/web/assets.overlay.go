@@ -0,0 +1,112 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// loadErrorPath is where GetUrl points scripts and stylesheets that fail to
+// load in ModeDevelopment, after the base url and before the virtual path.
+const loadErrorPath = "_error"
+
+// errorStubType returns "js" or "css" if a stub showing the load error of
+// virtualPath can be served in its place, and "" otherwise.
+func (f *Assets) errorStubType(virtualPath string) string {
+	f.lock.RLock()
+	entry := f.lookup(virtualPath)
+	contentType, extension := "", path.Ext(virtualPath)
+	if entry != nil {
+		contentType = entry.options.ContentType
+		if entry.path != "" {
+			extension = filepath.Ext(entry.path)
+		}
+	}
+	if contentType == "" {
+		contentType = f.contentTypes[extension]
+	}
+	f.lock.RUnlock()
+	if entry == nil {
+		return ""
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(extension)
+	}
+
+	switch {
+	case strings.Contains(contentType, "javascript"):
+		return "js"
+	case strings.Contains(contentType, "css"):
+		return "css"
+	}
+	return ""
+}
+
+// loadErrorURL returns the url of the error stub for virtualPath in
+// ModeDevelopment, or "" if there is none.
+func (f *Assets) loadErrorURL(virtualPath string) string {
+	if f.currentMode() != ModeDevelopment || f.errorStubType(virtualPath) == "" {
+		return ""
+	}
+	return f.baseURL + loadErrorPath + virtualPath
+}
+
+// serveLoadError serves virtualPath at its error stub url: the file itself
+// once it loads again, the stub otherwise.
+func (f *Assets) serveLoadError(w http.ResponseWriter, r *http.Request, virtualPath string) {
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		f.serveGetError(w, r, virtualPath, err)
+		return
+	}
+	f.serveFile(w, r, file, NoCachePolicy().CacheControl())
+}
+
+// serveGetError answers a request for virtualPath that failed to load. In
+// ModeDevelopment scripts and stylesheets are answered with a stub showing
+// the error on the page, as a failing sub-resource is easily missed; the stub
+// is served with 200 OK, as browsers ignore the body of other responses.
+func (f *Assets) serveGetError(w http.ResponseWriter, r *http.Request, virtualPath string, err error) {
+	stub := ""
+	if f.currentMode() == ModeDevelopment {
+		stub = f.errorStubType(virtualPath)
+	}
+
+	switch stub {
+	case "js":
+		message, _ := json.Marshal(err.Error())
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", NoCachePolicy().CacheControl())
+		w.Write([]byte("console.error(" + string(message) + ");\n"))
+	case "css":
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("Cache-Control", NoCachePolicy().CacheControl())
+		w.Write([]byte("body::before{content:" + cssString(err.Error()) + ";display:block;position:fixed;top:0;left:0;right:0;z-index:2147483647;" +
+			"padding:1em;background:#c00;color:#fff;font:14px/1.4 monospace;white-space:pre-wrap}\n"))
+	default:
+		f.renderError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// cssString quotes s as a css string.
+func cssString(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buf, "\\%x ", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
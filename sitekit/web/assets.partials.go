@@ -0,0 +1,75 @@
+package web
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// TemplatePartials parses the templates registered under the virtual
+// directory into every template set, after the templates passed to
+// GetTemplate, so shared partials don't have to be listed everywhere. Use
+// them by name, {{template "/templates/partials/nav.tmpl" .}}, or by the
+// names they define.
+func (f *Assets) TemplatePartials(directory string) {
+	if !strings.HasSuffix(directory, "/") {
+		directory += "/"
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.partials = append(f.partials, directory)
+	atomic.AddInt64(&f.version, 1)
+	f.resetTemplates()
+}
+
+// withPartials returns templatePathArr followed by the partials it doesn't
+// already include.
+func (f *Assets) withPartials(templatePathArr []string) []string {
+	f.lock.RLock()
+	directories := f.partials
+	f.lock.RUnlock()
+	if len(directories) == 0 {
+		return templatePathArr
+	}
+
+	included := make(map[string]bool)
+	for _, path := range templatePathArr {
+		included[path] = true
+	}
+	paths := append([]string(nil), templatePathArr...)
+	for _, path := range f.partialsOf(directories) {
+		if !included[path] {
+			included[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// partialPaths are the registered partials as of version.
+type partialPaths struct {
+	version int64
+	paths   []string
+}
+
+// partialsOf returns the registered files under directories, listing them
+// once per version rather than on every render.
+func (f *Assets) partialsOf(directories []string) []string {
+	version := atomic.LoadInt64(&f.version)
+	if cached, ok := f.partialPaths.Load().(partialPaths); ok && cached.version == version {
+		return cached.paths
+	}
+
+	var paths []string
+	for _, path := range f.Paths() {
+		for _, directory := range directories {
+			if strings.HasPrefix(path, directory) {
+				paths = append(paths, path)
+				break
+			}
+		}
+	}
+	f.partialPaths.Store(partialPaths{version: version, paths: paths})
+	return paths
+}
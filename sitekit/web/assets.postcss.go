@@ -0,0 +1,51 @@
+package web
+
+import (
+	"runtime"
+	"time"
+)
+
+// PostCSSConfig configures the PostCSS adapter. The command must read css on
+// stdin and write the result to stdout; plugins are picked up from the
+// project's postcss.config.js as usual.
+type PostCSSConfig struct {
+	Command string        // "npx" by default
+	Args    []string      // "postcss" by default; "{path}" is replaced with the virtual path
+	Workers int           // node processes running at once, runtime.NumCPU() by default
+	Timeout time.Duration // DefaultExecTimeout by default
+}
+
+// AddPostCSSPreprocessor runs PostCSS on .css and .scss files at
+// StagePostProcess, after compilation and before minification.
+func (f *Assets) AddPostCSSPreprocessor(config PostCSSConfig) {
+	processor := PostCSSPreprocessor(config)
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StagePostProcess, Processor: processor})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".scss", Stage: StagePostProcess, Processor: processor})
+}
+
+// PostCSSPreprocessor returns a Preprocessor that pipes content through
+// PostCSS. At most config.Workers processes run at once. With Assets.Cache
+// set, results are kept by command line and input, so unchanged stylesheets
+// are not processed again when files are re-added or the server restarts.
+func PostCSSPreprocessor(config PostCSSConfig) Preprocessor {
+	if config.Command == "" {
+		config.Command = "npx"
+		if len(config.Args) == 0 {
+			config.Args = []string{"postcss"}
+		}
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultExecTimeout
+	}
+
+	workers := make(chan struct{}, config.Workers)
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		workers <- struct{}{}
+		defer func() { <-workers }()
+
+		return assets.runCommandCached(config.Timeout, content, config.Command, expandArgs(config.Args, path)...)
+	}
+}
@@ -0,0 +1,175 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strings"
+	"text/template/parse"
+)
+
+const preloadLinksPlaceholder = template.HTML("<!--preloadlinks-->")
+
+// templateMeta is what GetTemplate learns about a template set from its
+// source.
+type templateMeta struct {
+	placeholders bool     // the output has placeholders to fill in
+	preload      []string // virtual paths declared with asset_preload
+	prefetch     []string // virtual paths declared with asset_prefetch
+}
+
+// getTemplateMeta returns the meta of the template set last loaded by
+// GetTemplate for templatePathArr.
+func (f *Assets) getTemplateMeta(templatePathArr []string) *templateMeta {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if meta := f.templateMeta[strings.Join(templatePathArr, "<")]; meta != nil {
+		return meta
+	}
+	return &templateMeta{}
+}
+
+// collectPreloads records the paths passed to asset_preload and
+// asset_prefetch anywhere in node. The paths must be string literals, as
+// they are needed before the template runs.
+func (m *templateMeta) collectPreloads(node parse.Node) error {
+	return walkParseTree(node, func(node parse.Node) error {
+		command, ok := node.(*parse.CommandNode)
+		if !ok {
+			return nil
+		}
+		identifier, ok := command.Args[0].(*parse.IdentifierNode)
+		if !ok || (identifier.Ident != "asset_preload" && identifier.Ident != "asset_prefetch") {
+			return nil
+		}
+		for _, arg := range command.Args[1:] {
+			path, ok := arg.(*parse.StringNode)
+			if !ok || !strings.HasPrefix(path.Text, "/") {
+				return errors.New(identifier.Ident + " needs paths starting with '/' as string literals, got " + arg.String())
+			}
+			if identifier.Ident == "asset_preload" {
+				m.preload = appendUnique(m.preload, path.Text)
+			} else {
+				m.prefetch = appendUnique(m.prefetch, path.Text)
+			}
+		}
+		return nil
+	})
+}
+
+// walkParseTree calls visit with node and every node below it, stopping at
+// the first error.
+func walkParseTree(node parse.Node, visit func(parse.Node) error) error {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return nil
+	}
+	if err := visit(node); err != nil {
+		return err
+	}
+
+	var children []parse.Node
+	switch node := node.(type) {
+	case *parse.ListNode:
+		children = node.Nodes
+	case *parse.ActionNode:
+		children = []parse.Node{node.Pipe}
+	case *parse.IfNode:
+		children = []parse.Node{node.Pipe, node.List, node.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{node.Pipe, node.List, node.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{node.Pipe, node.List, node.ElseList}
+	case *parse.TemplateNode:
+		children = []parse.Node{node.Pipe}
+	case *parse.PipeNode:
+		for _, command := range node.Cmds {
+			children = append(children, command)
+		}
+	case *parse.CommandNode:
+		children = node.Args
+	}
+	for _, child := range children {
+		if err := walkParseTree(child, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// TemplatePreloads returns the assets the templates in templatePathArr
+// declare with {{asset_preload "/path"}} and {{asset_prefetch "/path"}}.
+// RenderTemplate sends them as Link headers, and the preloadlinks template
+// func writes them as <link> tags, e.g. in <head>.
+func (f *Assets) TemplatePreloads(templatePathArr []string) (preload []string, prefetch []string, err error) {
+	if _, err := f.GetTemplate(templatePathArr); err != nil {
+		return nil, nil, err
+	}
+	meta := f.getTemplateMeta(templatePathArr)
+	return meta.preload, meta.prefetch, nil
+}
+
+// addTemplateLinkHeaders adds Link headers for the preloads declared by the
+// templates.
+func (f *Assets) addTemplateLinkHeaders(header http.Header, templatePathArr []string) error {
+	meta := f.getTemplateMeta(templatePathArr)
+	if err := f.AddPreloadHeaders(header, meta.preload...); err != nil {
+		return err
+	}
+	for _, path := range meta.prefetch {
+		url, err := f.GetUrl(path)
+		if err != nil {
+			return err
+		}
+		header.Add("Link", "<"+url+">; rel=prefetch")
+	}
+	return nil
+}
+
+// fillPreloadLinks replaces the preloadlinks placeholders in a rendered page
+// with <link> tags for the declared preloads.
+func (f *Assets) fillPreloadLinks(page []byte, meta *templateMeta) ([]byte, error) {
+	if !bytes.Contains(page, []byte(preloadLinksPlaceholder)) {
+		return page, nil
+	}
+
+	var buf bytes.Buffer
+	for _, path := range meta.preload {
+		file, err := f.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		url, err := f.GetUrl(path)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`<link rel="preload" href="` + html.EscapeString(url) + `"`)
+		if as := preloadAs(file.ContentType); as != "" {
+			buf.WriteString(` as="` + as + `"`)
+			if as == "font" {
+				buf.WriteString(" crossorigin")
+			}
+		}
+		buf.WriteString(">")
+	}
+	for _, path := range meta.prefetch {
+		url, err := f.GetUrl(path)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`<link rel="prefetch" href="` + html.EscapeString(url) + `">`)
+	}
+	return bytes.Replace(page, []byte(preloadLinksPlaceholder), buf.Bytes(), -1), nil
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"sort"
+	"strings"
+)
+
+// PreprocessorStage orders preprocessors: lower stages run first, and
+// preprocessors within a stage run in registration order. Values between the
+// predefined stages can be used for finer ordering.
+type PreprocessorStage int
+
+const (
+	// StageExpand fills in build-time values before anything else runs.
+	StageExpand PreprocessorStage = 10
+
+	// StageCompile turns source languages (SCSS, ...) into the content the
+	// later stages work on.
+	StageCompile PreprocessorStage = 50
+
+	// StageResolve rewrites references to other assets (url(), sourcemaps).
+	StageResolve PreprocessorStage = 100
+
+	// StageTransform compiles or otherwise changes content. AddPreprocessor
+	// and friends register at this stage.
+	StageTransform PreprocessorStage = 200
+
+	// StagePostProcess runs tools like PostCSS/autoprefixer on the compiled
+	// content, before it is minified.
+	StagePostProcess PreprocessorStage = 250
+
+	// StageMinify shrinks the final content.
+	StageMinify PreprocessorStage = 300
+)
+
+// PreprocessorRule registers a preprocessor for the files matching either an
+// extension (".css"), a virtual path glob ("/js/vendor/**") or a virtual
+// path prefix. A zero Stage runs before StageResolve. ContextProcessor is
+// used instead of Processor when set.
+type PreprocessorRule struct {
+	Extension        string
+	Glob             string
+	Prefix           string
+	Stage            PreprocessorStage
+	Processor        Preprocessor
+	ContextProcessor ContextPreprocessor
+}
+
+func (r *PreprocessorRule) matches(virtualPath string, extension string) bool {
+	switch {
+	case r.Glob != "":
+		return matchGlob(r.Glob, virtualPath)
+	case r.Prefix != "":
+		return strings.HasPrefix(virtualPath, r.Prefix)
+	}
+	return r.Extension == extension
+}
+
+// AddPreprocessor runs processor on files with the given extension (".css").
+func (f *Assets) AddPreprocessor(extension string, processor Preprocessor) {
+	f.AddPreprocessorRule(PreprocessorRule{Extension: extension, Stage: StageTransform, Processor: processor})
+}
+
+// AddContextPreprocessor runs processor on files with the given extension.
+func (f *Assets) AddContextPreprocessor(extension string, processor ContextPreprocessor) {
+	f.AddPreprocessorRule(PreprocessorRule{Extension: extension, Stage: StageTransform, ContextProcessor: processor})
+}
+
+// AddPreprocessorGlob runs processor on files whose virtual path matches
+// pattern, using the same syntax as Glob ("/js/vendor/**").
+func (f *Assets) AddPreprocessorGlob(pattern string, processor Preprocessor) {
+	f.AddPreprocessorRule(PreprocessorRule{Glob: pattern, Stage: StageTransform, Processor: processor})
+}
+
+// AddPreprocessorPrefix runs processor on files whose virtual path starts
+// with prefix.
+func (f *Assets) AddPreprocessorPrefix(prefix string, processor Preprocessor) {
+	f.AddPreprocessorRule(PreprocessorRule{Prefix: prefix, Stage: StageTransform, Processor: processor})
+}
+
+// AddPreprocessorRule registers a preprocessor with full control over what it
+// matches and the stage it runs in.
+func (f *Assets) AddPreprocessorRule(rule PreprocessorRule) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.preprocessors = append(f.preprocessors, rule)
+}
+
+// ClearPreprocessors removes the preprocessors registered for extension.
+func (f *Assets) ClearPreprocessors(extension string) {
+	f.clearPreprocessors(func(r *PreprocessorRule) bool { return r.Glob == "" && r.Prefix == "" && r.Extension == extension })
+}
+
+// ClearPreprocessorsGlob removes the preprocessors registered for pattern.
+func (f *Assets) ClearPreprocessorsGlob(pattern string) {
+	f.clearPreprocessors(func(r *PreprocessorRule) bool { return r.Glob == pattern })
+}
+
+// ClearPreprocessorsPrefix removes the preprocessors registered for prefix.
+func (f *Assets) ClearPreprocessorsPrefix(prefix string) {
+	f.clearPreprocessors(func(r *PreprocessorRule) bool { return r.Prefix == prefix })
+}
+
+func (f *Assets) clearPreprocessors(remove func(r *PreprocessorRule) bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	kept := make([]PreprocessorRule, 0, len(f.preprocessors))
+	for i := range f.preprocessors {
+		if !remove(&f.preprocessors[i]) {
+			kept = append(kept, f.preprocessors[i])
+		}
+	}
+	f.preprocessors = kept
+}
+
+// preprocessorsFor returns the rules that apply to a file, ordered by stage.
+// The caller must hold the lock.
+func (f *Assets) preprocessorsFor(virtualPath string, extension string) []PreprocessorRule {
+	var rules []PreprocessorRule
+	for i := range f.preprocessors {
+		if f.preprocessors[i].matches(virtualPath, extension) {
+			rules = append(rules, f.preprocessors[i])
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Stage < rules[j].Stage })
+	return rules
+}
@@ -0,0 +1,108 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DeclareDependencies records the assets a template needs, so Push and
+// EarlyHints can be driven by the templates being rendered.
+func (f *Assets) DeclareDependencies(templatePath string, paths ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.dependencies[templatePath] = append(f.dependencies[templatePath], paths...)
+}
+
+// TemplateDependencies returns the declared dependencies of all templates in
+// templatePathArr, without duplicates.
+func (f *Assets) TemplateDependencies(templatePathArr []string) []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	seen := make(map[string]bool)
+	paths := make([]string, 0)
+	for _, templatePath := range templatePathArr {
+		for _, path := range f.dependencies[templatePath] {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// Push initiates HTTP/2 server pushes of the given assets. It does nothing if
+// the connection doesn't support push.
+func (f *Assets) Push(w http.ResponseWriter, paths ...string) error {
+	pusher, ok := w.(http.Pusher)
+	if !ok {
+		return nil
+	}
+
+	for _, path := range paths {
+		// pushes are for this server, so they go to the mount path
+		url, err := f.getURL(path)
+		if err != nil {
+			return err
+		}
+		if err := pusher.Push(url, nil); err != nil {
+			if err == http.ErrNotSupported {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// EarlyHints adds Link: rel=preload headers for the given assets and sends
+// them in a 103 Early Hints response. The headers are kept for the final
+// response as well.
+func (f *Assets) EarlyHints(w http.ResponseWriter, paths ...string) error {
+	if err := f.AddPreloadHeaders(w.Header(), paths...); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+	return nil
+}
+
+// AddPreloadHeaders adds Link: rel=preload headers for the given assets.
+func (f *Assets) AddPreloadHeaders(header http.Header, paths ...string) error {
+	for _, path := range paths {
+		file, err := f.Get(path)
+		if err != nil {
+			return err
+		}
+		url, err := f.GetUrl(path)
+		if err != nil {
+			return err
+		}
+
+		link := "<" + url + ">; rel=preload"
+		if as := preloadAs(file.ContentType); as != "" {
+			link += "; as=" + as
+			if as == "font" {
+				link += "; crossorigin"
+			}
+		}
+		header.Add("Link", link)
+	}
+	return nil
+}
+
+// preloadAs returns the preload destination for a content type.
+func preloadAs(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/css"):
+		return "style"
+	case strings.Contains(contentType, "javascript"):
+		return "script"
+	case strings.HasPrefix(contentType, "font/"), strings.Contains(contentType, "font-"):
+		return "font"
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// reloadChanged unloads virtualPath, and the files it was compiled from, when
+// their files on disk have changed since they were loaded, so they are
+// processed again with the new content. The templates using them are parsed
+// again too, as the version changes. Used in ModeDevelopment.
+func (f *Assets) reloadChanged(virtualPath string, seen map[string]bool) {
+	if seen[virtualPath] {
+		return
+	}
+	seen[virtualPath] = true
+
+	f.lock.RLock()
+	entry := f.lookup(virtualPath)
+	if entry == nil {
+		f.lock.RUnlock()
+		return
+	}
+	file, loaded := entry.file, entry.loaded
+	sources := append(append([]string(nil), f.bundles[entry.virtualPath]...), f.sources[entry.virtualPath]...)
+	paths := []string{entry.path}
+	for _, source := range sources {
+		if sourceEntry := f.lookup(source); sourceEntry != nil {
+			paths = append(paths, sourceEntry.path)
+		}
+	}
+	f.lock.RUnlock()
+
+	// loaded sources unload the files compiled from them when they change
+	for _, source := range sources {
+		f.reloadChanged(source, seen)
+	}
+	if file == nil || !changedSince(loaded, paths) {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.entries[entry.virtualPath] == entry && entry.file == file {
+		f.entries[entry.virtualPath] = entry.reset()
+		f.invalidateDependents(entry.virtualPath, make(map[string]bool))
+		atomic.AddInt64(&f.version, 1)
+	}
+}
+
+// changedSince reports whether any of the files on disk was modified after t.
+// Empty paths are skipped, and missing files count as changed.
+func changedSince(t time.Time, paths []string) bool {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var renderBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledRenderBuffer keeps the odd huge page from pinning its buffer.
+const maxPooledRenderBuffer = 1 << 20
+
+func getRenderBuffer() *bytes.Buffer {
+	return renderBuffers.Get().(*bytes.Buffer)
+}
+
+func putRenderBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledRenderBuffer {
+		return
+	}
+	buf.Reset()
+	renderBuffers.Put(buf)
+}
+
+// RenderToBytes renders the last of templatePathArr and returns the output.
+func (f *Assets) RenderToBytes(templatePathArr []string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := f.RenderTemplateTo(templatePathArr, &buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTemplateTo renders the last of templatePathArr to w, which needn't
+// be an http.ResponseWriter: files for a static export, email bodies, test
+// snapshots. Nothing is written if rendering fails.
+func (f *Assets) RenderTemplateTo(templatePathArr []string, w io.Writer, data interface{}) error {
+	return f.RenderNamedTemplateTo(templatePathArr, templatePathArr[len(templatePathArr)-1], w, data)
+}
+
+// RenderNamedTemplateTo renders the template called name to w.
+func (f *Assets) RenderNamedTemplateTo(templatePathArr []string, name string, w io.Writer, data interface{}) error {
+	t, err := f.GetTemplate(templatePathArr)
+	if err != nil {
+		return err
+	}
+
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	if err := f.executeTemplate(t, templatePathArr, name, buf, data); err != nil {
+		return f.templateError(err)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// RenderFragment renders just the template or block called blockName from
+// the template set, e.g. the "content" block of a page for an htmx or turbo
+// request, without the layout around it. The set is the same as for the
+// full page and shares its cached parse.
+func (f *Assets) RenderFragment(templatePathArr []string, blockName string, w http.ResponseWriter, data interface{}) error {
+	t, err := f.GetTemplate(templatePathArr)
+	if err == nil && t.Lookup(blockName) == nil {
+		err = fmt.Errorf("%v: no template or block named %q", strings.Join(templatePathArr, ", "), blockName)
+	}
+	if err != nil {
+		err = f.newTemplateError(templatePathArr, blockName, data, err)
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	if err := f.executeTemplate(t, templatePathArr, blockName, buf, data); err != nil {
+		err = f.newTemplateError(templatePathArr, blockName, data, f.templateError(err))
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// RenderStatus renders the last of templatePathArr with the status code and
+// extra headers, e.g. a 404 page, sending them only once the template has
+// rendered. If it fails, the error page is sent with its own status instead.
+func (f *Assets) RenderStatus(code int, headers http.Header, templatePathArr []string, w http.ResponseWriter, data interface{}) error {
+	sw := &statusWriter{ResponseWriter: w, code: code, headers: headers}
+	if err := f.RenderTemplate(templatePathArr, sw, data); err != nil {
+		return err
+	}
+	sw.writeHeader()
+	return nil
+}
+
+// statusWriter sends its status code and headers before the first write,
+// unless WriteHeader was already called, e.g. by an error renderer.
+type statusWriter struct {
+	http.ResponseWriter
+	code    int
+	headers http.Header
+	written bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.writeHeader()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) writeHeader() {
+	if w.written {
+		return
+	}
+	for name, values := range w.headers {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(w.code)
+}
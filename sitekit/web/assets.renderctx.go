@@ -0,0 +1,181 @@
+package web
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// contextTemplates hands out copies of a template set with the context and
+// flush funcs bound to a render. Executed templates can't be cloned, so
+// the copies are cloned from parsed, which never runs, and reused through a
+// pool.
+type contextTemplates struct {
+	parsed *template.Template
+	pool   sync.Pool
+}
+
+type contextTemplate struct {
+	*template.Template
+	ctx   context.Context
+	flush func() error // set by RenderTemplateStream
+}
+
+func (c *contextTemplates) get() (*contextTemplate, error) {
+	if t, ok := c.pool.Get().(*contextTemplate); ok {
+		return t, nil
+	}
+	clone, err := c.parsed.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t := &contextTemplate{Template: clone}
+	clone.Funcs(template.FuncMap{
+		"context": func() context.Context { return t.ctx },
+		"flush": func() (string, error) {
+			if t.flush == nil {
+				return "", nil
+			}
+			return "", t.flush()
+		},
+	})
+	return t, nil
+}
+
+func (c *contextTemplates) put(t *contextTemplate) {
+	t.ctx, t.flush = nil, nil
+	c.pool.Put(t)
+}
+
+func (f *Assets) getContextTemplates(templatePathArr []string) (*contextTemplates, error) {
+	templatePaths, version := f.prepareTemplates(templatePathArr)
+
+	metaKey := strings.Join(templatePathArr, "<")
+	cacheKey := "ctx:" + metaKey
+	f.lock.RLock()
+	c := f.contextTemplates[cacheKey]
+	f.lock.RUnlock()
+	if c != nil {
+		return c, nil
+	}
+
+	parsed, err := f.parseOnce(cacheKey, func() (interface{}, error) {
+		tmpl, meta, err := f.parseTemplate(templatePaths, "", f.trimsWhitespace(templatePathArr))
+		if err != nil {
+			return nil, err
+		}
+		c := &contextTemplates{parsed: tmpl}
+		f.lock.Lock()
+		if f.cacheTemplateSet(version, templatePaths, cacheKey, metaKey) {
+			f.contextTemplates[cacheKey] = c
+			f.templateMeta[metaKey] = meta
+		}
+		f.lock.Unlock()
+		return c, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parsed.(*contextTemplates), nil
+}
+
+// contextWriter fails writes once ctx is done, which stops the template
+// writing to it.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// RenderTemplateCtx is RenderTemplate for a request: once ctx is done, e.g.
+// when the client disconnects, rendering stops and ctx.Err() is returned
+// without writing anything. Templates get ctx from the context func, to pass
+// on to funcs for tracing or per-request data: {{user context}}.
+func (f *Assets) RenderTemplateCtx(ctx context.Context, templatePathArr []string, w http.ResponseWriter, data interface{}) error {
+	return f.RenderNamedTemplateCtx(ctx, templatePathArr, templatePathArr[len(templatePathArr)-1], w, data)
+}
+
+// RenderNamedTemplateCtx is RenderTemplateCtx for the template called name.
+func (f *Assets) RenderNamedTemplateCtx(ctx context.Context, templatePathArr []string, name string, w http.ResponseWriter, data interface{}) error {
+	return f.renderCtx(ctx, templatePathArr, name, w, data, false)
+}
+
+// RenderTemplateStream is RenderTemplateCtx sending what has rendered so far
+// at each {{flush}}, so the browser can start on the head and layout while a
+// slow part of the page, e.g. a func in data calling a backend, renders:
+//
+//	<head>...</head><body><nav>...</nav>{{flush}}{{range .Comments}}...
+//
+// Once output has been sent a failing template can't be replaced by an error
+// page, and the error is only returned. Minified templates and templates
+// using the inlinecritical or preloadlinks placeholders need the whole page,
+// so they ignore flush.
+func (f *Assets) RenderTemplateStream(ctx context.Context, templatePathArr []string, w http.ResponseWriter, data interface{}) error {
+	return f.renderCtx(ctx, templatePathArr, templatePathArr[len(templatePathArr)-1], w, data, true)
+}
+
+func (f *Assets) renderCtx(ctx context.Context, templatePathArr []string, name string, w http.ResponseWriter, data interface{}, stream bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c, err := f.getContextTemplates(templatePathArr)
+	if err != nil {
+		err = f.newTemplateError(templatePathArr, name, data, err)
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+	if err := f.addTemplateLinkHeaders(w.Header(), templatePathArr); err != nil {
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+	t, err := c.get()
+	if err != nil {
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+	defer c.put(t)
+	t.ctx = ctx
+
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	flushed := false
+	if stream && f.templateMinifyOptions(templatePathArr) == nil && !f.getTemplateMeta(templatePathArr).placeholders {
+		t.flush = func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+			flushed = true
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return nil
+		}
+	}
+	err = f.executeTemplate(t.Template, templatePathArr, name, contextWriter{ctx, buf}, data)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		err = f.newTemplateError(templatePathArr, name, data, f.templateError(err))
+		if !flushed {
+			f.renderError(w, nil, http.StatusInternalServerError, err)
+		}
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
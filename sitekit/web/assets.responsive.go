@@ -0,0 +1,134 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type responsiveImage struct {
+	width       int
+	virtualPath string
+}
+
+// Responsive generates resized variants of the image at virtualPath for each
+// of widths, registered at paths like /images/hero-480w.jpg when the image is
+// loaded. Widths larger than the image are skipped. The srcset template func
+// lists the variants. Call it before the image is loaded.
+func (f *Assets) Responsive(virtualPath string, widths ...int) {
+	f.AddPreprocessorRule(PreprocessorRule{Prefix: virtualPath, Stage: StageMinify + 20, Processor: func(assets *Assets, path string, content []byte) ([]byte, error) {
+		if path != virtualPath {
+			return content, nil
+		}
+		return content, assets.generateResponsive(path, content, widths)
+	}})
+}
+
+func (f *Assets) generateResponsive(virtualPath string, content []byte, widths []int) error {
+	img, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("%v: %v", virtualPath, err)
+	}
+
+	extension := path.Ext(virtualPath)
+	images := []responsiveImage{{width: img.Bounds().Dx(), virtualPath: virtualPath}}
+	for _, width := range widths {
+		if width <= 0 || width >= img.Bounds().Dx() {
+			continue
+		}
+
+		var buf bytes.Buffer
+		resized := resizeImage(img, width)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, resized)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", virtualPath, err)
+		}
+
+		resizedPath := strings.TrimSuffix(virtualPath, extension) + "-" + strconv.Itoa(width) + "w" + extension
+		f.AddContent(resizedPath, buf.Bytes(), FileOptions{ContentType: "image/" + format})
+		images = append(images, responsiveImage{width: width, virtualPath: resizedPath})
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].width < images[j].width })
+
+	f.lock.Lock()
+	f.responsive[virtualPath] = images
+	f.lock.Unlock()
+	return nil
+}
+
+// SrcSet returns a srcset attribute listing the fingerprinted urls of the
+// variants of the image at virtualPath declared with Responsive.
+func (f *Assets) SrcSet(virtualPath string) (template.HTMLAttr, error) {
+	if _, err := f.Get(virtualPath); err != nil {
+		return "", err
+	}
+
+	f.lock.RLock()
+	images := f.responsive[virtualPath]
+	f.lock.RUnlock()
+	if images == nil {
+		return "", fmt.Errorf("%v: no responsive variants declared", virtualPath)
+	}
+
+	candidates := make([]string, len(images))
+	for i, responsive := range images {
+		url, err := f.GetUrl(responsive.virtualPath)
+		if err != nil {
+			return "", err
+		}
+		candidates[i] = url + " " + strconv.Itoa(responsive.width) + "w"
+	}
+	return template.HTMLAttr(`srcset="` + html.EscapeString(strings.Join(candidates, ", ")) + `"`), nil
+}
+
+// resizeImage scales img down to width, averaging the source pixels covered
+// by each target pixel.
+func resizeImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	resized := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(bounds.Min.Y, bounds.Dy(), height, y)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(bounds.Min.X, bounds.Dx(), width, x)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(sr), g+uint64(sg), b+uint64(sb), a+uint64(sa), n+1
+				}
+			}
+			resized.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return resized
+}
+
+// span returns the source range [from, to) covered by target pixel i when
+// scaling size source pixels starting at min to count target pixels.
+func span(min int, size int, count int, i int) (int, int) {
+	from := min + i*size/count
+	to := min + (i+1)*size/count
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"regexp"
+	"strings"
+)
+
+var urlSchemeRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// jsImportRegexp matches the specifiers of static and dynamic imports and of
+// re-exports: import "./a.js", import x from "./a.js", import("./a.js"),
+// export * from "./a.js".
+var jsImportRegexp = regexp.MustCompile(`(?:\bfrom|\bimport)\s*\(?\s*["']([^"'\n]+)["']`)
+
+// DefaultHTMLURLAttributes are the attributes rewritten in static html when no
+// others are given.
+var DefaultHTMLURLAttributes = []string{"src", "href"}
+
+// AddURLRewritePreprocessors rewrites relative and rooted import specifiers
+// in .js and .mjs files, and the given attributes (DefaultHTMLURLAttributes
+// if none) in .html and .htm files, to fingerprinted urls.
+func (f *Assets) AddURLRewritePreprocessors(htmlAttributes ...string) {
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".js", Stage: StageResolve, Processor: AssetJSPreprocessor})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".mjs", Stage: StageResolve, Processor: AssetJSPreprocessor})
+
+	processor := HTMLURLPreprocessor(htmlAttributes...)
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".html", Stage: StageResolve, Processor: processor})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".htm", Stage: StageResolve, Processor: processor})
+}
+
+// AssetJSPreprocessor rewrites "./", "../" and "/" import specifiers to
+// fingerprinted urls. Bare specifiers ("lodash") are left alone; an import of
+// an unregistered file is an error.
+func AssetJSPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
+	return URLRewritePreprocessor(jsImportRegexp, true)(assets, path, content)
+}
+
+// HTMLURLPreprocessor returns a Preprocessor rewriting the given attributes
+// (DefaultHTMLURLAttributes if none) to fingerprinted urls, when they refer to
+// registered files. Links to pages and external urls are left alone.
+func HTMLURLPreprocessor(attributes ...string) Preprocessor {
+	if len(attributes) == 0 {
+		attributes = DefaultHTMLURLAttributes
+	}
+	quoted := make([]string, len(attributes))
+	for i, attribute := range attributes {
+		quoted[i] = regexp.QuoteMeta(attribute)
+	}
+	pattern := regexp.MustCompile(`(?i)\s(?:` + strings.Join(quoted, "|") + `)\s*=\s*["']([^"']+)["']`)
+	return URLRewritePreprocessor(pattern, false)
+}
+
+// URLRewritePreprocessor returns a Preprocessor rewriting the references
+// captured by the first group of pattern to fingerprinted urls. References
+// are resolved relative to the file. When strict, bare names ("lodash") are
+// module names and left alone, and references to unregistered files are an
+// error. Otherwise bare names are relative paths ("img/a.png") and references
+// to unregistered files are kept as they are.
+func URLRewritePreprocessor(pattern *regexp.Regexp, strict bool) Preprocessor {
+	return func(assets *Assets, path string, content []byte) ([]byte, error) {
+		var rewriteErr error
+		result := pattern.ReplaceAllFunc(content, func(match []byte) []byte {
+			if rewriteErr != nil {
+				return match
+			}
+			location := pattern.FindSubmatchIndex(match)
+			reference := string(match[location[2]:location[3]])
+			if !isLocalReference(reference, !strict) || assets.isAssetURL(reference) {
+				return match
+			}
+
+			// keep query strings and fragments
+			suffix := ""
+			if i := strings.IndexAny(reference, "?#"); i != -1 {
+				reference, suffix = reference[:i], reference[i:]
+			}
+
+			rootedPath, err := assets.getRooted(path, reference)
+			if err != nil {
+				rewriteErr = err
+				return match
+			}
+			assets.lock.RLock()
+			registered := assets.lookup(rootedPath) != nil
+			assets.lock.RUnlock()
+			if !registered && !strict {
+				return match
+			}
+
+			url, err := assets.getURL(rootedPath)
+			if err != nil {
+				rewriteErr = err
+				return match
+			}
+
+			rewritten := append([]byte(nil), match[:location[2]]...)
+			rewritten = append(rewritten, url+suffix...)
+			return append(rewritten, match[location[3]:]...)
+		})
+		if rewriteErr != nil {
+			return nil, rewriteErr
+		}
+		return result, nil
+	}
+}
+
+// isLocalReference reports whether reference is a path relative to the file
+// or the site root, as opposed to an external url or an in-page anchor.
+func isLocalReference(reference string, bareIsRelative bool) bool {
+	switch {
+	case reference == "", strings.HasPrefix(reference, "//"), strings.HasPrefix(reference, "#"):
+		return false
+	case strings.HasPrefix(reference, "/"), strings.HasPrefix(reference, "./"), strings.HasPrefix(reference, "../"):
+		return true
+	}
+	return bareIsRelative && !urlSchemeRegexp.MatchString(reference)
+}
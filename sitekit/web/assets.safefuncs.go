@@ -0,0 +1,65 @@
+package web
+
+import (
+	"html/template"
+	"reflect"
+	"sort"
+	"sync/atomic"
+)
+
+// unescapedTypes are the types html/template writes without escaping.
+var unescapedTypes = map[reflect.Type]bool{
+	reflect.TypeOf(template.HTML("")):     true,
+	reflect.TypeOf(template.HTMLAttr("")): true,
+	reflect.TypeOf(template.JS("")):       true,
+	reflect.TypeOf(template.JSStr("")):    true,
+	reflect.TypeOf(template.CSS("")):      true,
+	reflect.TypeOf(template.URL("")):      true,
+	reflect.TypeOf(template.Srcset("")):   true,
+}
+
+// bypassesEscaping reports whether templateFunc returns one of the types
+// html/template doesn't escape.
+func bypassesEscaping(templateFunc interface{}) bool {
+	t := reflect.TypeOf(templateFunc)
+	return t != nil && t.Kind() == reflect.Func && t.NumOut() > 0 && unescapedTypes[t.Out(0)]
+}
+
+// SafeFuncs forbids template funcs returning content html/template doesn't
+// escape, such as template.HTML and template.JS, except those named in
+// allowed, so the ways around escaping stay few and easy to audit. The asset
+// funcs are allowed, but not jscode or the safe funcs of StandardFuncs.
+// Registered funcs that aren't allowed are removed, so templates using them
+// fail to parse, and SetTemplateFunc panics on them.
+func (f *Assets) SafeFuncs(allowed ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.safeFuncs = true
+	for _, name := range allowed {
+		f.trustedFuncs[name] = true
+	}
+	for name, templateFunc := range f.templateFuncMap {
+		if !f.trustedFuncs[name] && bypassesEscaping(templateFunc) {
+			delete(f.templateFuncMap, name)
+		}
+	}
+	atomic.AddInt64(&f.version, 1)
+	f.resetTemplates()
+}
+
+// UnsafeFuncs returns the names of the registered template funcs returning
+// content html/template doesn't escape, for review.
+func (f *Assets) UnsafeFuncs() []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	var names []string
+	for name, templateFunc := range f.templateFuncMap {
+		if bypassesEscaping(templateFunc) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
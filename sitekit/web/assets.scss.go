@@ -0,0 +1,211 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+var scssImportRegexp = regexp.MustCompile(`(?m)^[ \t]*@import[ \t]+([^;\n]+);`)
+var scssUseRegexp = regexp.MustCompile(`(?m)^[ \t]*@(use|forward)[ \t]+["']([^"'\n]+)["']`)
+
+// AddScssPreprocessor compiles .scss files to css by piping them through an
+// external compiler, as there is no scss compiler written in Go. compiler
+// defaults to "sass --stdin", the Dart Sass command line tool, which must be
+// installed and on PATH. Files starting with an underscore are partials and
+// are only compiled into the files importing them. Stylesheets are limited to
+// @import: the compiler reads a single flattened stylesheet and can't find
+// the files of @use and @forward, so those are reported as errors, except for
+// the sass: built-in modules.
+func (f *Assets) AddScssPreprocessor(compiler string, args ...string) {
+	if compiler == "" {
+		compiler, args = "sass", []string{"--stdin"}
+	}
+	f.SetContentType(".scss", "text/css; charset=utf-8")
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".scss", Stage: StageCompile, Processor: ScssPreprocessor(compiler, args...)})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".scss", Stage: StageResolve, Processor: AssetCssPreprocessor})
+}
+
+// ScssPreprocessor returns a Preprocessor that resolves @import statements
+// through the registered virtual files and pipes the result through compiler.
+// The imported files are recorded, so re-adding one of them reloads the
+// files that import it.
+func ScssPreprocessor(compiler string, args ...string) Preprocessor {
+	return func(assets *Assets, virtualPath string, content []byte) ([]byte, error) {
+		if strings.HasPrefix(path.Base(virtualPath), "_") {
+			return content, nil
+		}
+
+		var imported []string
+		flattened, err := assets.flattenScss(virtualPath, content, map[string]bool{virtualPath: true}, &imported)
+		assets.setSources(virtualPath, imported)
+		if err != nil {
+			return nil, err
+		}
+		compiled, err := assets.runCommandCached(DefaultExecTimeout, flattened, compiler, expandArgs(args, virtualPath)...)
+		if err != nil {
+			if _, lookErr := exec.LookPath(compiler); lookErr != nil {
+				return nil, fmt.Errorf("scss compiler %q not found, install Dart Sass or pass a compiler to AddScssPreprocessor", compiler)
+			}
+			return nil, err
+		}
+		return compiled, nil
+	}
+}
+
+// flattenScss replaces @import statements with the content of the imported
+// files. Plain css imports (url(), .css, http) are left for the browser.
+func (f *Assets) flattenScss(virtualPath string, content []byte, importing map[string]bool, imported *[]string) ([]byte, error) {
+	for _, match := range scssUseRegexp.FindAllSubmatch(content, -1) {
+		if !bytes.HasPrefix(match[2], []byte("sass:")) {
+			return nil, &PreprocessError{Path: virtualPath, Err: fmt.Errorf("@%s %q is not supported, use @import", match[1], match[2])}
+		}
+	}
+
+	var err error
+	result := scssImportRegexp.ReplaceAllFunc(content, func(statement []byte) []byte {
+		if err != nil {
+			return statement
+		}
+
+		var kept []string
+		var buffer bytes.Buffer
+		for _, argument := range strings.Split(string(scssImportRegexp.FindSubmatch(statement)[1]), ",") {
+			argument = strings.TrimSpace(argument)
+			name := strings.Trim(argument, "\"'")
+			if isCSSImport(argument, name) {
+				kept = append(kept, argument)
+				continue
+			}
+
+			resolved := f.resolveScssImport(path.Dir(virtualPath), name)
+			if resolved == "" {
+				err = &PreprocessError{Path: virtualPath, Err: fmt.Errorf("could not resolve @import %v", argument)}
+				return statement
+			}
+			if importing[resolved] {
+				err = &PreprocessError{Path: virtualPath, Err: fmt.Errorf("import cycle through %v", resolved)}
+				return statement
+			}
+			*imported = append(*imported, resolved)
+
+			var source, flattened []byte
+			source, err = f.readSource(resolved)
+			if err != nil {
+				return statement
+			}
+			importing[resolved] = true
+			flattened, err = f.flattenScss(resolved, source, importing, imported)
+			delete(importing, resolved)
+			if err != nil {
+				return statement
+			}
+			buffer.Write(flattened)
+			buffer.WriteByte('\n')
+		}
+
+		if len(kept) > 0 {
+			buffer.WriteString("@import " + strings.Join(kept, ", ") + ";")
+		}
+		return buffer.Bytes()
+	})
+	return result, err
+}
+
+func isCSSImport(argument string, name string) bool {
+	return strings.HasPrefix(argument, "url(") ||
+		strings.HasSuffix(name, ".css") ||
+		strings.HasPrefix(name, "http://") ||
+		strings.HasPrefix(name, "https://") ||
+		strings.HasPrefix(name, "//")
+}
+
+// resolveScssImport finds the virtual path for an import the way sass does:
+// the name itself, with .scss, as a _partial or as a directory index.
+func (f *Assets) resolveScssImport(directory string, name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = path.Join(directory, name)
+	}
+	dir, base := path.Split(name)
+	candidates := []string{name}
+	if path.Ext(name) != ".scss" {
+		candidates = append(candidates,
+			name+".scss",
+			dir+"_"+base+".scss",
+			name+"/_index.scss",
+			name+"/index.scss",
+		)
+	} else {
+		candidates = append(candidates, dir+"_"+base)
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, candidate := range candidates {
+		if f.lookup(candidate) != nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// readSource reads the unprocessed content of a registered file.
+func (f *Assets) readSource(virtualPath string) ([]byte, error) {
+	f.lock.RLock()
+	entry := f.lookup(virtualPath)
+	f.lock.RUnlock()
+	if entry == nil {
+		return nil, errors.New("File Not Found: " + virtualPath)
+	}
+	return entry.read()
+}
+
+// Sources returns the virtual paths that were compiled into virtualPath, e.g.
+// the files imported by a stylesheet or the members of a bundle. Imports are
+// known once the file is loaded.
+func (f *Assets) Sources(virtualPath string) []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return append(append([]string(nil), f.bundles[virtualPath]...), f.sources[virtualPath]...)
+}
+
+func (f *Assets) setSources(virtualPath string, sources []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.sources[virtualPath] = sources
+}
+
+// invalidateDependents replaces the files that were compiled from
+// virtualPath with unloaded copies, so they are processed again on next use,
+// and evicts the template sets parsed from any of them. The caller must hold
+// the lock.
+func (f *Assets) invalidateDependents(virtualPath string, seen map[string]bool) {
+	f.evictTemplates(virtualPath)
+	for _, dependencies := range []map[string][]string{f.sources, f.bundles} {
+		for dependent, sources := range dependencies {
+			if seen[dependent] {
+				continue
+			}
+			for _, source := range sources {
+				if source != virtualPath {
+					continue
+				}
+				seen[dependent] = true
+				if entry := f.entries[dependent]; entry != nil {
+					f.entries[dependent] = entry.reset()
+					f.logDebug("asset invalidated", logkit.String("path", dependent), logkit.String("source", virtualPath))
+				}
+				f.invalidateDependents(dependent, seen)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package web
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// ServeHTTP serves the asset for the request URL path, which must start with
+// the base URL, so Assets can be mounted directly with mux.Handle.
+func (f *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Serve(r.URL.Path, w, r)
+}
+
+func (f *Assets) Serve(url string, w http.ResponseWriter, r *http.Request) {
+	// a nil request is served like a plain GET
+	if r == nil {
+		r = &http.Request{Method: "GET", Header: make(http.Header), URL: &neturl.URL{Path: url}}
+	}
+
+	if !strings.HasPrefix(url, f.baseURL) {
+		f.notFound(w, r)
+		return
+	}
+	if f.currentMode() == ModeDevelopment && url == f.baseURL+liveReloadPath {
+		f.serveLiveReload(w, r)
+		return
+	}
+	if f.currentMode() == ModeDevelopment && strings.HasPrefix(url, f.baseURL+loadErrorPath+"/") {
+		f.serveLoadError(w, r, url[len(f.baseURL+loadErrorPath):])
+		return
+	}
+
+	if f.URLFormat == URLQuery {
+		f.serveQueryVersioned(w, r, "/"+url[len(f.baseURL):])
+		return
+	}
+
+	f.lock.RLock()
+	file := f.fileByURL(url[len(f.baseURL):])
+	f.lock.RUnlock()
+
+	if file == nil {
+		f.notFound(w, r)
+		return
+	}
+
+	f.serveFile(w, r, file, f.cacheControl(file))
+}
+
+// serveQueryVersioned serves virtualPath for the URLQuery format. Only a
+// request for the current version (?v=<hash>) may be cached.
+func (f *Assets) serveQueryVersioned(w http.ResponseWriter, r *http.Request, virtualPath string) {
+	f.lock.RLock()
+	registered := f.lookup(virtualPath) != nil
+	f.lock.RUnlock()
+	if !registered {
+		f.notFound(w, r)
+		return
+	}
+
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		f.serveGetError(w, r, virtualPath, err)
+		return
+	}
+
+	cacheControl := NoCachePolicy().CacheControl()
+	if r.URL.Query().Get("v") == file.checksum {
+		cacheControl = f.cacheControl(file)
+	}
+	f.serveFile(w, r, file, cacheControl)
+}
+
+func (f *Assets) cacheControl(file *File) string {
+	if file.options.CacheControl != "" {
+		return file.options.CacheControl
+	}
+	if f.currentMode() == ModeDevelopment {
+		return NoCachePolicy().CacheControl()
+	}
+	return f.CachePolicy.forExtension(file.extension()).CacheControl()
+}
+
+// ServeVirtual serves the file registered at the request URL path, e.g.
+// /css/site.css, with Cache-Control: no-cache. It bypasses fingerprinting so
+// URLs stay stable during local development.
+//
+// Directories resolve to their index.html, and a directory requested without
+// a trailing slash is redirected to the URL with one.
+func (f *Assets) ServeVirtual(w http.ResponseWriter, r *http.Request) {
+	virtualPath := r.URL.Path
+	if strings.HasSuffix(virtualPath, "/") {
+		virtualPath += "index.html"
+	}
+
+	f.lock.RLock()
+	registered := f.lookup(virtualPath) != nil
+	isDirectory := f.lookup(virtualPath+"/index.html") != nil
+	f.lock.RUnlock()
+	if !registered {
+		if isDirectory {
+			target := *r.URL
+			target.Path += "/"
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+			return
+		}
+		f.notFound(w, r)
+		return
+	}
+
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		f.serveGetError(w, r, virtualPath, err)
+		return
+	}
+
+	f.serveFile(w, r, file, NoCachePolicy().CacheControl())
+}
+
+func (f *Assets) serveFile(w http.ResponseWriter, r *http.Request, file *File, cacheControl string) {
+	if !f.servesSourceMap(r, file) {
+		f.notFound(w, r)
+		return
+	}
+	cacheControl = f.privateCacheControl(file, cacheControl)
+
+	variant, hasVariants := f.imageVariant(r, file)
+	if hasVariants {
+		w.Header().Add("Vary", "Accept")
+	}
+	if variant != nil {
+		file = variant
+	}
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	f.SecurityHeaders.write(w.Header())
+	for key, values := range file.options.Headers {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	if f.isDownload(file) {
+		filename := file.options.Filename
+		if filename == "" {
+			filename = path.Base(file.virtualPath)
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+
+	if f.writeCORS(w, r, file) {
+		return
+	}
+
+	// every response varies by encoding, so caches keep the variants apart
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	etag := "\"" + file.HashString + "\""
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// range requests are always served from the identity encoding
+	available := file.encodings()
+	if file.streamed {
+		available = f.Compression.streamEncodings()
+	}
+	if r.Header.Get("Range") != "" {
+		available = nil
+	}
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), available)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	if file.streamed && encoding != "" {
+		if r.Method == "HEAD" {
+			length, err := f.streamedLength(file, encoding)
+			if err != nil {
+				f.renderError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(length))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// the status is sent by now, so a failure only truncates the body
+		if err := f.writeStreamed(w, file, encoding); err != nil {
+			f.logWarn("streaming compressed asset failed", logkit.String("path", file.virtualPath), logkit.Err(err))
+		}
+		return
+	}
+
+	// ServeContent handles HEAD, Range and If-Range for us, but leaves out
+	// Content-Length when a Content-Encoding is set.
+	content := file.encoded(encoding)
+	if encoding != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// SecurityHeaders are added to every asset response when set.
+type SecurityHeaders struct {
+	NoSniff                   bool   // X-Content-Type-Options: nosniff
+	CrossOriginResourcePolicy string // e.g. "same-site" or "cross-origin"
+	TimingAllowOrigin         string // e.g. "*"
+}
+
+func (s *SecurityHeaders) write(header http.Header) {
+	if s.NoSniff {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+	if s.CrossOriginResourcePolicy != "" {
+		header.Set("Cross-Origin-Resource-Policy", s.CrossOriginResourcePolicy)
+	}
+	if s.TimingAllowOrigin != "" {
+		header.Set("Timing-Allow-Origin", s.TimingAllowOrigin)
+	}
+}
+
+// isDownload reports whether file should be served as an attachment, either
+// by its options or by matching one of the DownloadPrefixes.
+func (f *Assets) isDownload(file *File) bool {
+	if file.options.Download {
+		return true
+	}
+	for _, prefix := range f.DownloadPrefixes {
+		if strings.HasPrefix(file.virtualPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison required for If-None-Match.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
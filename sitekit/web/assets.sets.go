@@ -0,0 +1,73 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DefineTemplate registers the template set templatePathArr under name, so
+// handlers can render it by name with Render instead of repeating the paths.
+// As with RenderTemplate, the last template is the one rendered.
+func (f *Assets) DefineTemplate(name string, templatePathArr ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.templateSets[name] = templatePathArr
+}
+
+// LoadTemplateDefinitions defines the template sets listed in the JSON asset
+// at virtualPath, an object mapping names to template paths:
+// {"article": ["/t/layout.html", "/t/article.html"]}.
+func (f *Assets) LoadTemplateDefinitions(virtualPath string) error {
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		return err
+	}
+
+	var definitions map[string][]string
+	if err := json.Unmarshal(file.Content, &definitions); err != nil {
+		return fmt.Errorf("%v: %v", virtualPath, err)
+	}
+	for name, templatePathArr := range definitions {
+		if len(templatePathArr) == 0 {
+			return fmt.Errorf("%v: template set %v has no templates", virtualPath, name)
+		}
+	}
+	for name, templatePathArr := range definitions {
+		f.DefineTemplate(name, templatePathArr...)
+	}
+	return nil
+}
+
+// TemplateSet returns the templates defined under name.
+func (f *Assets) TemplateSet(name string) ([]string, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	templatePathArr, found := f.templateSets[name]
+	if !found {
+		return nil, errors.New("Template Set Not Defined: " + name)
+	}
+	return templatePathArr, nil
+}
+
+// Render renders the template set defined under name.
+func (f *Assets) Render(name string, w http.ResponseWriter, data interface{}) error {
+	templatePathArr, err := f.TemplateSet(name)
+	if err != nil {
+		f.renderError(w, nil, http.StatusInternalServerError, err)
+		return err
+	}
+	return f.RenderTemplate(templatePathArr, w, data)
+}
+
+// RenderString is Render returning the output.
+func (f *Assets) RenderString(name string, data interface{}) (string, error) {
+	templatePathArr, err := f.TemplateSet(name)
+	if err != nil {
+		return "", err
+	}
+	return f.RenderTemplateString(templatePathArr, data)
+}
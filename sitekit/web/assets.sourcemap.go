@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SourceMapPolicy selects how source maps are published in production.
+// Development always publishes them.
+type SourceMapPolicy int
+
+const (
+	// SourceMapsPublic serves .map assets to everyone and points the
+	// sourceMappingURL comments at them.
+	SourceMapsPublic SourceMapPolicy = iota
+
+	// SourceMapsPrivate keeps the comments, but serves .map assets only to
+	// requests allowed by SourceMapAuth, e.g. from staff or an error tracker.
+	SourceMapsPrivate
+
+	// SourceMapsStrip removes the sourceMappingURL comments and does not
+	// serve .map assets at all.
+	SourceMapsStrip
+)
+
+func (f *Assets) sourceMapPolicy() SourceMapPolicy {
+	if f.currentMode() == ModeDevelopment {
+		return SourceMapsPublic
+	}
+	return f.SourceMaps
+}
+
+// AttachSourceMap registers sourceMap for the file at virtualPath, served at
+// virtualPath + ".map", and returns content with its sourceMappingURL comment
+// pointing at the fingerprinted url of the map. Preprocessors that transform
+// content call it with the map describing their output. With SourceMapsStrip
+// the map is dropped and content is returned without the comment.
+func (f *Assets) AttachSourceMap(virtualPath string, content []byte, sourceMap []byte) ([]byte, error) {
+	content = bytes.TrimRight(sourceMapCommentRegex.ReplaceAll(content, nil), "\n")
+	if f.sourceMapPolicy() == SourceMapsStrip {
+		return append(content, '\n'), nil
+	}
+
+	mapPath := virtualPath + ".map"
+	f.AddContent(mapPath, sourceMap, FileOptions{ContentType: "application/json"})
+	url, err := f.getURL(mapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if path.Ext(virtualPath) == ".css" {
+		return append(content, []byte("\n/*# sourceMappingURL="+url+" */\n")...), nil
+	}
+	return append(content, []byte("\n//# sourceMappingURL="+url+"\n")...), nil
+}
+
+// SourceMapStripPreprocessor removes sourceMappingURL comments with
+// SourceMapsStrip, and leaves content alone otherwise. NewAssets adds it for
+// .js files.
+func SourceMapStripPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
+	if assets.sourceMapPolicy() != SourceMapsStrip {
+		return content, nil
+	}
+	return sourceMapCommentRegex.ReplaceAll(content, nil), nil
+}
+
+// servesSourceMap reports whether file may be served for r. Anything other
+// than a source map always may.
+func (f *Assets) servesSourceMap(r *http.Request, file *File) bool {
+	if !strings.HasSuffix(file.virtualPath, ".map") {
+		return true
+	}
+	switch f.sourceMapPolicy() {
+	case SourceMapsPrivate:
+		return f.SourceMapAuth != nil && f.SourceMapAuth(r)
+	case SourceMapsStrip:
+		return false
+	}
+	return true
+}
+
+// privateCacheControl keeps shared caches from handing a source map served
+// under SourceMapsPrivate to someone else.
+func (f *Assets) privateCacheControl(file *File, cacheControl string) string {
+	if f.sourceMapPolicy() != SourceMapsPrivate || !strings.HasSuffix(file.virtualPath, ".map") {
+		return cacheControl
+	}
+	if strings.HasPrefix(cacheControl, "public") {
+		return "private" + cacheControl[len("public"):]
+	}
+	return "private, " + cacheControl
+}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"html"
+	"html/template"
+)
+
+// integrity returns the Subresource Integrity value (sha384) of content.
+func integrity(content []byte) string {
+	sum := sha512.Sum384(content)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ScriptTag returns a <script> tag loading the asset at virtualPath by its
+// fingerprinted url, with an integrity attribute.
+func (f *Assets) ScriptTag(virtualPath string) (template.HTML, error) {
+	url, file, err := f.urlAndFile(virtualPath)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<script src="` + html.EscapeString(url) + `"` + integrityAttributes(file) + `></script>`), nil
+}
+
+// StylesheetTag returns a <link rel="stylesheet"> tag loading the asset at
+// virtualPath by its fingerprinted url, with an integrity attribute.
+func (f *Assets) StylesheetTag(virtualPath string) (template.HTML, error) {
+	url, file, err := f.urlAndFile(virtualPath)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<link rel="stylesheet" href="` + html.EscapeString(url) + `"` + integrityAttributes(file) + `>`), nil
+}
+
+// urlAndFile returns the url and file of virtualPath. In development a file
+// failing to load gives the url of its error stub and a nil file.
+func (f *Assets) urlAndFile(virtualPath string) (string, *File, error) {
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		if url := f.loadErrorURL(virtualPath); url != "" {
+			return f.publicize(url), nil, nil
+		}
+		return "", nil, err
+	}
+	return f.publicize(f.fileURL(file)), file, nil
+}
+
+func integrityAttributes(file *File) string {
+	if file == nil {
+		return ""
+	}
+	return ` integrity="` + file.Integrity + `" crossorigin="anonymous"`
+}
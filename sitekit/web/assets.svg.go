@@ -0,0 +1,74 @@
+package web
+
+import (
+	"errors"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/tdewolff/minify"
+	"github.com/tdewolff/minify/svg"
+)
+
+var svgMinifier = minify.New()
+var svgPrologRegexp = regexp.MustCompile(`(?s)^\s*(<\?xml.*?\?>\s*)?(<!DOCTYPE[^>]*>\s*)?(<!--.*?-->\s*)*`)
+var svgTagRegexp = regexp.MustCompile(`<svg\b[^>]*>`)
+
+func init() {
+	svgMinifier.AddFunc("image/svg+xml", svg.Minify)
+}
+
+// SVGMinifyPreprocessor minifies svg images.
+func SVGMinifyPreprocessor(assets *Assets, path string, content []byte) ([]byte, error) {
+	return svgMinifier.Bytes("image/svg+xml", content)
+}
+
+// AddSVGMinifyPreprocessor minifies .svg files.
+func (f *Assets) AddSVGMinifyPreprocessor() {
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".svg", Stage: StageMinify, Processor: SVGMinifyPreprocessor})
+}
+
+// InlineSVG returns the processed markup of the svg at virtualPath for
+// embedding in html, without its xml prolog. attributes are name/value pairs
+// set on the <svg> element; a class is added to the existing ones.
+func (f *Assets) InlineSVG(virtualPath string, attributes ...string) (template.HTML, error) {
+	if len(attributes)%2 != 0 {
+		return "", errors.New("inlinesvg: attributes must be name/value pairs")
+	}
+	file, err := f.Get(virtualPath)
+	if err != nil {
+		return "", err
+	}
+
+	markup := svgPrologRegexp.ReplaceAllString(string(file.Content), "")
+	location := svgTagRegexp.FindStringIndex(markup)
+	if location == nil {
+		return "", errors.New(virtualPath + ": no <svg> element")
+	}
+
+	tag := markup[location[0]:location[1]]
+	for i := 0; i < len(attributes); i += 2 {
+		tag = setAttribute(tag, attributes[i], attributes[i+1])
+	}
+	return template.HTML(markup[:location[0]] + tag + markup[location[1]:]), nil
+}
+
+// setAttribute sets name to value in the start tag, appending to the
+// existing value for class.
+func setAttribute(tag string, name string, value string) string {
+	existing := regexp.MustCompile(`\s` + regexp.QuoteMeta(name) + `\s*=\s*("[^"]*"|'[^']*')`)
+	if location := existing.FindStringSubmatchIndex(tag); location != nil {
+		if name == "class" {
+			old := html.UnescapeString(tag[location[2]+1 : location[3]-1])
+			value = strings.TrimSpace(old + " " + value)
+		}
+		return tag[:location[0]] + ` ` + name + `="` + html.EscapeString(value) + `"` + tag[location[1]:]
+	}
+
+	end := len(tag) - 1
+	if strings.HasSuffix(tag, "/>") {
+		end--
+	}
+	return tag[:end] + ` ` + name + `="` + html.EscapeString(value) + `"` + tag[end:]
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// ObjectStore is the object storage a CDN serves assets from, e.g. an S3,
+// GCS or Azure bucket, adapted with the client library of the provider.
+type ObjectStore interface {
+	// Hash returns the hash stored with the object at key by Put, or "" if
+	// there is no such object.
+	Hash(ctx context.Context, key string) (string, error)
+
+	// Put stores content at key with the given metadata.
+	Put(ctx context.Context, key string, content []byte, meta ObjectMeta) error
+}
+
+// ObjectMeta is the metadata an object is stored with, to be sent as
+// headers when it is served.
+type ObjectMeta struct {
+	ContentType     string
+	ContentEncoding string // "" for identity
+	CacheControl    string
+	Hash            string // hash of the asset, to skip it on the next Sync
+}
+
+// SyncReport lists the keys of the objects Sync stored and skipped, sorted.
+type SyncReport struct {
+	Uploaded []string
+	Skipped  []string
+}
+
+// Sync processes every registered file and uploads it to store under its
+// url below the base url, along with its compressed variants at the same key
+// plus .gz, .zst and .br, like Export. Assets whose hash is already stored
+// at their key are skipped, variants included, so only changes are
+// uploaded. Uploads run on BuildWorkers goroutines.
+func (f *Assets) Sync(ctx context.Context, store ObjectStore) (*SyncReport, error) {
+	if err := f.loadAll(ctx, f.Paths()); err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	report := &SyncReport{}
+	err := f.parallel(ctx, f.Paths(), func(virtualPath string) error {
+		if !f.exports(virtualPath) {
+			return nil
+		}
+		file, err := f.Get(virtualPath)
+		if err != nil {
+			return err
+		}
+
+		key := f.exportName(file)
+		hash, err := store.Hash(ctx, key)
+		if err != nil {
+			return err
+		}
+		if hash == file.HashString {
+			lock.Lock()
+			report.Skipped = append(report.Skipped, key)
+			lock.Unlock()
+			return nil
+		}
+
+		variants, err := f.exportVariants(file)
+		if err != nil {
+			return err
+		}
+		meta := ObjectMeta{ContentType: file.ContentType, CacheControl: f.CachePolicy.forExtension(file.extension()).CacheControl(), Hash: file.HashString}
+		if file.options.CacheControl != "" {
+			meta.CacheControl = file.options.CacheControl
+		}
+		// the variants go first, so a failed upload is retried on the next Sync
+		uploaded := make([]string, 0, len(variants)+1)
+		for _, variant := range variants {
+			variantMeta := meta
+			variantMeta.ContentEncoding = variant.encoding
+			if err := store.Put(ctx, key+variant.extension, variant.content, variantMeta); err != nil {
+				return err
+			}
+			uploaded = append(uploaded, key+variant.extension)
+		}
+		if err := store.Put(ctx, key, file.Content, meta); err != nil {
+			return err
+		}
+
+		lock.Lock()
+		report.Uploaded = append(report.Uploaded, append(uploaded, key)...)
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(report.Uploaded)
+	sort.Strings(report.Skipped)
+	return report, nil
+}
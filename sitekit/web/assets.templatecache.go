@@ -0,0 +1,117 @@
+package web
+
+import (
+	"html/template"
+	"strings"
+	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// cacheTemplateSet records that the template cache keys were parsed from
+// paths, so changing one of them, or the file an alias among them points to,
+// evicts just those sets. It reports false, and records nothing, if files
+// changed since version, as the set may have been parsed from stale content.
+// The caller must hold the lock.
+func (f *Assets) cacheTemplateSet(version int64, paths []string, keys ...string) bool {
+	if version != atomic.LoadInt64(&f.version) {
+		return false
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		f.addTemplateMembers(path, keys)
+		if target, found := f.aliases[path]; found {
+			f.addTemplateMembers(target, keys)
+		}
+	}
+	return true
+}
+
+// addTemplateMembers records that the template cache keys were parsed from
+// path. The caller must hold the lock.
+func (f *Assets) addTemplateMembers(path string, keys []string) {
+	sets := f.templateCacheMembers[path]
+	if sets == nil {
+		sets = make(map[string]bool)
+		f.templateCacheMembers[path] = sets
+	}
+	for _, key := range keys {
+		sets[key] = true
+	}
+}
+
+// evictTemplates drops the cached template sets parsed from virtualPath. A
+// file in a partials directory is added to every set, so it drops them all.
+// The caller must hold the lock.
+func (f *Assets) evictTemplates(virtualPath string) {
+	for _, directory := range f.partials {
+		if strings.HasPrefix(virtualPath, directory) {
+			f.resetTemplates()
+			return
+		}
+	}
+
+	if len(f.templateCacheMembers[virtualPath]) > 0 {
+		f.logDebug("templates evicted", logkit.String("path", virtualPath), logkit.Int("sets", len(f.templateCacheMembers[virtualPath])))
+	}
+	for key := range f.templateCacheMembers[virtualPath] {
+		delete(f.templateCache, key)
+		delete(f.textTemplateCache, key)
+		delete(f.templateMeta, key)
+		delete(f.contextTemplates, key)
+	}
+	delete(f.templateCacheMembers, virtualPath)
+}
+
+// resetTemplates drops all cached template sets, for changes that affect
+// every template such as delimiters, funcs and partials. The caller must hold
+// the lock.
+func (f *Assets) resetTemplates() {
+	f.logDebug("templates reset")
+	f.templateCache = make(map[string]*template.Template)
+	f.textTemplateCache = make(map[string]*texttemplate.Template)
+	f.templateMeta = make(map[string]*templateMeta)
+	f.contextTemplates = make(map[string]*contextTemplates)
+	f.templateCacheMembers = make(map[string]map[string]bool)
+}
+
+// templateCall is a template set being parsed, for the goroutines waiting on it.
+type templateCall struct {
+	done   sync.WaitGroup
+	parsed interface{}
+	err    error
+}
+
+// parseOnce calls parse, or if another goroutine already is parsing the set
+// at key, waits for it and shares its result.
+func (f *Assets) parseOnce(key string, parse func() (interface{}, error)) (interface{}, error) {
+	f.lock.Lock()
+	if call, found := f.templateCalls[key]; found {
+		f.lock.Unlock()
+		call.done.Wait()
+		return call.parsed, call.err
+	}
+	call := &templateCall{}
+	call.done.Add(1)
+	f.templateCalls[key] = call
+	f.lock.Unlock()
+
+	started := time.Now()
+	call.parsed, call.err = parse()
+	if call.err != nil {
+		f.logWarn("parsing template failed", logkit.String("templates", key), logkit.Err(call.err))
+	} else {
+		f.logDebug("template parsed", logkit.String("templates", key), logkit.Duration("duration", time.Since(started)))
+	}
+
+	f.lock.Lock()
+	delete(f.templateCalls, key)
+	f.lock.Unlock()
+	call.done.Done()
+	return call.parsed, call.err
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// GetTextTemplate is GetTemplate for text/template, for output that isn't
+// html and must not be escaped as such: emails, robots.txt, sitemaps and
+// config snippets. The asset template funcs are available.
+func (f *Assets) GetTextTemplate(templatePathArr []string) (*template.Template, error) {
+	templatePaths, version := f.prepareTemplates(templatePathArr)
+
+	cacheKey := strings.Join(templatePathArr, "<")
+	f.lock.RLock()
+	tmpl := f.textTemplateCache[cacheKey]
+	f.lock.RUnlock()
+	if tmpl != nil {
+		return tmpl, nil
+	}
+
+	parsed, err := f.parseOnce("text:"+cacheKey, func() (interface{}, error) {
+		return f.parseTextTemplate(templatePaths, version, cacheKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parsed.(*template.Template), nil
+}
+
+func (f *Assets) parseTextTemplate(templatePaths []string, version int64, cacheKey string) (*template.Template, error) {
+	funcs := template.FuncMap(f.localizedFuncs(""))
+	left, right := f.TemplateDelims()
+
+	tmpl := template.New("temp-outer-template-shell").Funcs(funcs)
+	for _, path := range templatePaths {
+		if path == "" {
+			continue
+		}
+		file, err := f.Get(path)
+		if err != nil {
+			return nil, err
+		}
+
+		temp, err := template.New(path).Delims(left, right).Funcs(funcs).Parse(string(file.Content))
+		if err != nil {
+			return nil, newPreprocessError(path, file.Content, err)
+		}
+		for _, t := range temp.Templates() {
+			if tmpl.Lookup(t.Name()) == nil {
+				tmpl.AddParseTree(t.Name(), t.Tree)
+			}
+		}
+	}
+
+	f.lock.Lock()
+	if f.cacheTemplateSet(version, templatePaths, cacheKey) {
+		f.textTemplateCache[cacheKey] = tmpl
+	}
+	f.lock.Unlock()
+
+	return tmpl, nil
+}
+
+// RenderTextTemplate renders the last of templatePathArr as a text template.
+func (f *Assets) RenderTextTemplate(templatePathArr []string, w io.Writer, data interface{}) error {
+	return f.RenderNamedTextTemplate(templatePathArr, templatePathArr[len(templatePathArr)-1], w, data)
+}
+
+// RenderNamedTextTemplate renders the template called name as a text
+// template.
+func (f *Assets) RenderNamedTextTemplate(templatePathArr []string, name string, w io.Writer, data interface{}) error {
+	t, err := f.GetTextTemplate(templatePathArr)
+	if err != nil {
+		return err
+	}
+	if err := t.ExecuteTemplate(w, name, data); err != nil {
+		return f.templateError(err)
+	}
+	return nil
+}
+
+// RenderTextTemplateString renders the last of templatePathArr as a text
+// template and returns the output.
+func (f *Assets) RenderTextTemplateString(templatePathArr []string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := f.RenderTextTemplate(templatePathArr, &buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"path"
+	"strings"
+)
+
+// URLFormat selects how GetUrl builds fingerprinted urls.
+type URLFormat int
+
+const (
+	// URLHash gives urls like /a/<hash>.
+	URLHash URLFormat = iota
+
+	// URLNamedHash keeps the base name and extension of the file, giving urls
+	// like /a/site.<hash>.css, which CDNs and browser devtools handle better.
+	URLNamedHash
+
+	// URLQuery keeps the path stable and puts the hash in the query string,
+	// giving urls like /a/css/site.css?v=<hash>. Use a base url of "/" to
+	// serve assets at their virtual paths.
+	URLQuery
+)
+
+// fileURL returns the url that file is served at.
+func (f *Assets) fileURL(file *File) string {
+	switch f.URLFormat {
+	case URLNamedHash:
+		base := path.Base(file.virtualPath)
+		extension := path.Ext(base)
+		return f.baseURL + strings.TrimSuffix(base, extension) + "." + file.checksum + extension
+	case URLQuery:
+		return f.baseURL + strings.TrimPrefix(file.virtualPath, "/") + "?v=" + file.checksum
+	}
+	return f.baseURL + file.checksum
+}
+
+// fileByURL returns the loaded file served at name, the part of a url
+// following the base url, or nil. Both the plain (<hash>) and the named
+// (<name>.<hash>.<ext>, or <name>.<hash> for files without an extension) url
+// formats are understood. The caller must hold the lock.
+func (f *Assets) fileByURL(name string) *File {
+	parts := strings.Split(name, ".")
+	if len(parts) == 1 {
+		return f.byChecksum[name]
+	}
+	if file := f.byChecksum[parts[len(parts)-2]]; file != nil {
+		return file
+	}
+	return f.byChecksum[parts[len(parts)-1]]
+}
+
+// isAssetURL reports whether url already is the url of a loaded asset, as
+// opposed to a reference still to be resolved.
+func (f *Assets) isAssetURL(url string) bool {
+	if !strings.HasPrefix(url, f.baseURL) {
+		return false
+	}
+	if f.URLFormat == URLQuery {
+		return strings.Contains(url, "?v=")
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.lookup(url) == nil && f.fileByURL(url[len(f.baseURL):]) != nil
+}
+
+// SetPublicURL makes the urls handed to pages start with prefix instead of
+// the base url, e.g. "https://cdn.example.com/a/" to load assets from a CDN,
+// while Serve keeps answering at the base url. It can be changed at any
+// time: manifests and processed content refer to assets by the base url, so
+// nothing is processed again and one build serves every environment. As
+// stylesheets and scripts refer to other assets by path, the prefix should
+// end in the base url unless they never do. An empty prefix restores the
+// base url.
+func (f *Assets) SetPublicURL(prefix string) {
+	f.publicURL.Store(prefix)
+}
+
+// PublicURL returns the prefix of the urls handed to pages.
+func (f *Assets) PublicURL() string {
+	if prefix, _ := f.publicURL.Load().(string); prefix != "" {
+		return prefix
+	}
+	return f.baseURL
+}
+
+// publicize replaces the base url at the start of url with the public url.
+func (f *Assets) publicize(url string) string {
+	if !strings.HasPrefix(url, f.baseURL) {
+		return url
+	}
+	return f.PublicURL() + url[len(f.baseURL):]
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template/parse"
+)
+
+// assetFuncs are the template funcs taking a virtual path, with whether all
+// their arguments are paths rather than just the first.
+var assetFuncs = map[string]bool{
+	"asset":          false,
+	"script":         false,
+	"stylesheet":     false,
+	"srcset":         false,
+	"inlinesvg":      false,
+	"markdown":       false,
+	"frontmatter":    false,
+	"bundle":         false,
+	"picture":        false,
+	"assetinline":    false,
+	"inlinecritical": false,
+	"bundlemanifest": false,
+	"asset_preload":  true,
+	"asset_prefetch": true,
+}
+
+// ReferenceError is a reference to an asset that isn't registered.
+type ReferenceError struct {
+	Path      string // virtual path of the file with the reference
+	Reference string
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("%v: %v is not a registered asset", e.Path, e.Reference)
+}
+
+// Verify checks that the assets referenced from templates, with the asset
+// funcs and string literal paths, and from stylesheets, with url(), are
+// registered or in the loaded manifest, catching typos before a deploy
+// rather than on the first request. Files are read but not processed. All
+// problems are returned together as TemplateErrors of ReferenceErrors and
+// template parse errors.
+func (f *Assets) Verify() error {
+	var errs TemplateErrors
+	for _, virtualPath := range f.Paths() {
+		switch path.Ext(virtualPath) {
+		case ".tmpl", ".html":
+			errs = append(errs, f.verifyTemplate(virtualPath)...)
+		case ".css", ".scss":
+			errs = append(errs, f.verifyStylesheet(virtualPath)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (f *Assets) verifyTemplate(virtualPath string) []error {
+	t, err := f.GetTemplate([]string{virtualPath})
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, defined := range t.Templates() {
+		// partials are verified on their own
+		if defined.Tree == nil || defined.Tree.ParseName != virtualPath {
+			continue
+		}
+		walkParseTree(defined.Tree.Root, func(node parse.Node) error {
+			command, ok := node.(*parse.CommandNode)
+			if !ok || len(command.Args) < 2 {
+				return nil
+			}
+			identifier, ok := command.Args[0].(*parse.IdentifierNode)
+			if !ok {
+				return nil
+			}
+			all, found := assetFuncs[identifier.Ident]
+			if !found {
+				return nil
+			}
+			arguments := command.Args[1:2]
+			if all {
+				arguments = command.Args[1:]
+			}
+			for _, argument := range arguments {
+				if reference, ok := argument.(*parse.StringNode); ok && !f.resolves(reference.Text) {
+					errs = append(errs, &ReferenceError{Path: virtualPath, Reference: reference.Text})
+				}
+			}
+			return nil
+		})
+	}
+	return errs
+}
+
+func (f *Assets) verifyStylesheet(virtualPath string) []error {
+	source, err := f.readSource(virtualPath)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, match := range cssUrlRegex.FindAll(source, -1) {
+		reference := strings.TrimSpace(strings.TrimPrefix(string(match[len("url("):len(match)-1]), "base64:"))
+		if reference == "" || strings.HasPrefix(reference, "data:") || strings.HasPrefix(reference, "//") || urlSchemeRegexp.MatchString(reference) {
+			continue
+		}
+		rooted, err := f.getRooted(virtualPath, reference)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !f.resolves(rooted) {
+			errs = append(errs, &ReferenceError{Path: virtualPath, Reference: reference})
+		}
+	}
+	return errs
+}
+
+// resolves reports whether GetUrl knows virtualPath, without loading it.
+func (f *Assets) resolves(virtualPath string) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if f.lookup(virtualPath) != nil {
+		return true
+	}
+	_, found := f.manifest[virtualPath]
+	return found
+}
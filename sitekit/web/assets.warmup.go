@@ -0,0 +1,52 @@
+package web
+
+import (
+	"context"
+	"strings"
+)
+
+// Preload processes the assets at paths during startup, before the server
+// accepts traffic, so the first requests for them don't wait. Paths may be
+// Glob patterns. Loading starts in the order given, so list the critical
+// assets first, and follow up with PreloadAll for the rest.
+func (f *Assets) Preload(paths ...string) error {
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, virtualPath := range paths {
+		matches := []string{virtualPath}
+		if strings.ContainsAny(virtualPath, "*?[") {
+			var err error
+			if matches, err = f.Glob(virtualPath); err != nil {
+				return err
+			}
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				expanded = append(expanded, match)
+			}
+		}
+	}
+	return f.loadAll(context.Background(), expanded)
+}
+
+// PreloadAll processes every registered asset and parses the template sets
+// defined with DefineTemplate. Unlike BuildAll it does not check the Budgets.
+func (f *Assets) PreloadAll() error {
+	if err := f.loadAll(context.Background(), f.Paths()); err != nil {
+		return err
+	}
+
+	f.lock.RLock()
+	sets := make([][]string, 0, len(f.templateSets))
+	for _, set := range f.templateSets {
+		sets = append(sets, set)
+	}
+	f.lock.RUnlock()
+	for _, set := range sets {
+		if _, err := f.GetTemplate(set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
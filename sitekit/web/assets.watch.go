@@ -0,0 +1,142 @@
+package web
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oliverkofoed/gokit/logkit"
+)
+
+// Watch watches the files behind the registered assets, and the directories
+// added with AddDirectory, until ctx is done. Changed files are processed
+// again on next use, along with the files and templates using them, and
+// files created in the directories are added. Unlike the checks of
+// ModeDevelopment, this costs nothing per request. In ModeDevelopment, pages
+// using the livereload template func reload when a file changes.
+func (f *Assets) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	watched := make(map[string]bool)
+	for directory := range f.directories {
+		watched[directory] = true
+	}
+	for _, entry := range f.entries {
+		if entry.path != "" {
+			watched[filepath.Dir(entry.path)] = true
+		}
+	}
+	f.watcher = watcher
+	f.lock.Unlock()
+
+	for directory := range watched {
+		if err := watchTree(watcher, directory); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	go f.watch(ctx, watcher)
+	return nil
+}
+
+// watchTree watches directory and the directories in it.
+func watchTree(watcher *fsnotify.Watcher, directory string) error {
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		return watcher.Add(path)
+	})
+}
+
+func (f *Assets) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer func() {
+		f.lock.Lock()
+		if f.watcher == watcher {
+			f.watcher = nil
+		}
+		f.lock.Unlock()
+		watcher.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if err := f.fileChanged(watcher, filepath.Clean(event.Name), event.Op); err != nil {
+				f.logWarn("reloading asset failed", logkit.String("path", event.Name), logkit.Err(err))
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			f.logWarn("watching assets failed", logkit.Err(err))
+		}
+	}
+}
+
+// fileChanged unloads the assets read from path, or adds path if it was
+// created in a directory added with AddDirectory.
+func (f *Assets) fileChanged(watcher *fsnotify.Watcher, path string, op fsnotify.Op) error {
+	f.lock.Lock()
+	var unloaded []string
+	for virtualPath, entry := range f.entries {
+		if entry.path != "" && filepath.Clean(entry.path) == path {
+			f.entries[virtualPath] = entry.reset()
+			f.invalidateDependents(virtualPath, make(map[string]bool))
+			unloaded = append(unloaded, virtualPath)
+		}
+	}
+	if len(unloaded) > 0 {
+		atomic.AddInt64(&f.version, 1)
+	}
+	directory, virtualPath := f.directoryOf(path)
+	f.lock.Unlock()
+
+	for _, virtualPath := range unloaded {
+		f.notifyChanged(virtualPath)
+	}
+	if len(unloaded) > 0 || op&fsnotify.Create == 0 || directory == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil // already gone again
+	}
+	rel, err := filepath.Rel(directory, path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if err := watchTree(watcher, path); err != nil {
+			return err
+		}
+		return f.AddDirectory(path, virtualPath+rel+"/")
+	}
+	f.AddFile(path, virtualPath+rel)
+	f.notifyChanged(virtualPath + rel)
+	return nil
+}
+
+// directoryOf returns the innermost directory added with AddDirectory that
+// contains path, and its virtual path. The caller must hold the lock.
+func (f *Assets) directoryOf(path string) (string, string) {
+	var found, foundVirtualPath string
+	for directory, virtualPath := range f.directories {
+		if strings.HasPrefix(path, directory+string(filepath.Separator)) && len(directory) > len(found) {
+			found, foundVirtualPath = directory, virtualPath
+		}
+	}
+	return found, foundVirtualPath
+}
@@ -1,10 +1,33 @@
 package web
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"html/template"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/oliverkofoed/gokit/logkit"
 	"github.com/oliverkofoed/gokit/testkit"
 )
 
@@ -64,3 +87,2879 @@ func TestFileSystem(t *testing.T) {
 	testkit.NoError(t, f.RenderTemplate([]string{"/templates/funcs.tmpl"}, w, nil))
 	testkit.Equal(t, string(w.Body.Bytes()), string(file.Content)+"\n/a/"+file.HashString)
 }
+
+func TestFileOptions(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddFileWithOptions("testassets/templates/simple.txt", "/sw.js", FileOptions{
+		ContentType:  "application/javascript",
+		Headers:      http.Header{"Service-Worker-Allowed": []string{"/"}},
+		CacheControl: "no-cache",
+	})
+
+	url, err := f.GetUrl("/sw.js")
+	testkit.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Header().Get("Content-Type"), "application/javascript")
+	testkit.Equal(t, w.Header().Get("Service-Worker-Allowed"), "/")
+	testkit.Equal(t, w.Header().Get("Cache-Control"), "no-cache")
+	testkit.Equal(t, w.Header().Get("Expires"), "")
+}
+
+func TestWalk(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	paths := f.Paths()
+	testkit.Equal(t, len(paths), 8)
+	testkit.Equal(t, paths[0], "/css/red.png")
+	testkit.Equal(t, paths[1], "/css/test.css")
+
+	walked := make([]string, 0)
+	testkit.NoError(t, f.Walk(func(virtualPath string, file *File) error {
+		testkit.Assert(t, file.HashString != "")
+		walked = append(walked, virtualPath)
+		return nil
+	}))
+	testkit.Equal(t, walked, paths)
+}
+
+func TestGlob(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	matches, err := f.Glob("/css/*.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, matches, []string{"/css/test.css"})
+
+	matches, err = f.Glob("/**/*.png")
+	testkit.NoError(t, err)
+	testkit.Equal(t, matches, []string{"/css/red.png", "/images/red.png"})
+
+	_, err = f.Glob("/css/[")
+	testkit.Error(t, err)
+}
+
+func TestAlias(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	testkit.Error(t, f.Alias("/favicon.ico", "/images/unknown.png"))
+	testkit.NoError(t, f.Alias("/favicon.ico", "/images/red.png"))
+
+	alias, err := f.Get("/favicon.ico")
+	testkit.NoError(t, err)
+	file, err := f.Get("/images/red.png")
+	testkit.NoError(t, err)
+	testkit.Assert(t, alias == file)
+}
+
+func TestBuildAll(t *testing.T) {
+	f := NewAssets("/a/")
+	f.BuildWorkers = 4
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+	report, err := f.BuildAll(context.Background())
+	testkit.NoError(t, err)
+
+	testkit.NoError(t, f.Walk(func(virtualPath string, file *File) error {
+		testkit.Assert(t, file.Content != nil)
+		return nil
+	}))
+
+	// the report covers every file, with the preprocessors that ran
+	testkit.Equal(t, len(report.Assets), len(f.Paths()))
+	for _, asset := range report.Assets {
+		testkit.Assert(t, asset.Hash != "")
+		if asset.VirtualPath == "/css/test.css" {
+			testkit.Assert(t, len(asset.Preprocessors) > 0)
+			testkit.Equal(t, asset.Size > 0, true)
+		}
+	}
+	testkit.Assert(t, strings.Contains(report.String(), "web.AssetCssPreprocessor"))
+
+	f.AddFile("testassets/missing.css", "/css/missing.css")
+	_, err = f.BuildAll(context.Background())
+	testkit.Error(t, err)
+}
+
+func TestZstd(t *testing.T) {
+	f := NewAssets("/a/")
+	f.Compression.Zstd = true
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	r := httptest.NewRequest("GET", url, nil)
+	r.Header.Set("Accept-Encoding", "gzip, zstd")
+	w := httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Header().Get("Content-Encoding"), "zstd")
+
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Header().Get("Content-Encoding"), "gzip")
+}
+
+func TestCompressionConfig(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/images/red.png")
+	testkit.NoError(t, err)
+	testkit.Assert(t, file.ContentGZipped == nil)
+
+	url, err := f.GetUrl("/images/red.png")
+	testkit.NoError(t, err)
+	r := httptest.NewRequest("GET", url, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Header().Get("Content-Encoding"), "")
+	testkit.Equal(t, w.Body.Bytes(), file.Content)
+
+	f.Compression.MinSize = 1 << 20
+	f.AddFile("testassets/css/test.css", "/css/large-only.css")
+	file, err = f.Get("/css/large-only.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, file.ContentGZipped == nil)
+}
+
+func TestCompressionMinSavings(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, file.ContentGZipped != nil)
+
+	// nothing compresses 99%, so only the identity copy is kept.
+	f = NewAssets("/a/")
+	f.Compression.MinSavingsPercent = 99
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+	file, err = f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, file.ContentGZipped == nil)
+}
+
+func TestCompressionSidecars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := []byte("body { color: red; }")
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.css"), content, 0644))
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.css.gz"), []byte("offline-gzip"), 0644))
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.css.br"), []byte("offline-brotli"), 0644))
+
+	f := NewAssets("/a/")
+	f.ClearPreprocessors(".css")
+	f.AddFile(filepath.Join(dir, "site.css"), "/site.css")
+
+	file, err := f.Get("/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, file.Content, content)
+	testkit.Equal(t, string(file.ContentGZipped), "offline-gzip")
+	testkit.Equal(t, string(file.ContentBrotli), "offline-brotli")
+
+	// a compressed variant that is larger than the content is never served.
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.css.br"), bytes.Repeat([]byte("x"), 100), 0644))
+	f.AddFile(filepath.Join(dir, "site.css"), "/site.css")
+	file, err = f.Get("/site.css")
+	testkit.NoError(t, err)
+	r := httptest.NewRequest("GET", "/site.css", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	w := httptest.NewRecorder()
+	f.ServeVirtual(w, r)
+	testkit.Equal(t, w.Header().Get("Content-Encoding"), "gzip")
+
+	// stale sidecars, older than the file, are ignored.
+	stale := time.Now().Add(-time.Hour)
+	testkit.NoError(t, os.Chtimes(filepath.Join(dir, "site.css.gz"), stale, stale))
+	f.AddFile(filepath.Join(dir, "site.css"), "/site.css")
+	file, err = f.Get("/site.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, string(file.ContentGZipped) != "offline-gzip")
+	testkit.NoError(t, os.Chtimes(filepath.Join(dir, "site.css.gz"), time.Now(), time.Now()))
+
+	// sidecars are ignored once a preprocessor changes the content.
+	f.AddPreprocessor(".css", func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return append(content, '\n'), nil
+	})
+	f.AddFile(filepath.Join(dir, "site.css"), "/site2.css")
+	file, err = f.Get("/site2.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, string(file.ContentGZipped) != "offline-gzip")
+	testkit.Assert(t, file.ContentBrotli == nil)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	all := []string{"br", "zstd", "gzip"}
+	testkit.Equal(t, negotiateEncoding("", all), "")
+	testkit.Equal(t, negotiateEncoding("gzip", all), "gzip")
+	testkit.Equal(t, negotiateEncoding("gzip, deflate, br", all), "br")
+	testkit.Equal(t, negotiateEncoding("gzip, zstd", all), "zstd")
+	testkit.Equal(t, negotiateEncoding("gzip;q=0", all), "")
+	testkit.Equal(t, negotiateEncoding("br;q=0.5, gzip", all), "gzip")
+	testkit.Equal(t, negotiateEncoding("*", all), "br")
+	testkit.Equal(t, negotiateEncoding("*, br;q=0", all), "zstd")
+	testkit.Equal(t, negotiateEncoding("gzip;q=0.5, identity", all), "")
+	testkit.Equal(t, negotiateEncoding("br", []string{"gzip"}), "")
+	testkit.Equal(t, negotiateEncoding("x-gzip-not, GZIP", []string{"gzip"}), "gzip")
+}
+
+func TestCompressionStreaming(t *testing.T) {
+	f := NewAssets("/a/")
+	f.Compression.StreamThreshold = 10
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, file.ContentGZipped == nil)
+
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	// HEAD before anything is cached has the length of the GET response
+	r := httptest.NewRequest("HEAD", url, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Header().Get("Content-Encoding"), "gzip")
+	testkit.Equal(t, w.Body.Len(), 0)
+	length := w.Header().Get("Content-Length")
+	testkit.Assert(t, length != "")
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		f.Serve(url, w, r)
+		testkit.Equal(t, w.Header().Get("Content-Encoding"), "gzip")
+		testkit.Equal(t, strconv.Itoa(w.Body.Len()), length)
+
+		reader, err := gzip.NewReader(w.Body)
+		testkit.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		testkit.NoError(t, err)
+		testkit.Equal(t, content, file.Content)
+	}
+	testkit.Assert(t, f.streamCache.get(streamCacheKey(file, "gzip")) != nil)
+}
+
+func TestETag(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	f.Serve(url, w, httptest.NewRequest("GET", url, nil))
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Header().Get("ETag"), "\""+file.HashString+"\"")
+
+	r := httptest.NewRequest("GET", url, nil)
+	r.Header.Set("If-None-Match", "\"other\", W/\""+file.HashString+"\"")
+	w = httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Code, 304)
+	testkit.Equal(t, w.Body.Len(), 0)
+
+	r.Header.Set("If-None-Match", "\"other\"")
+	w = httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Code, 200)
+}
+
+func TestRange(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	r := httptest.NewRequest("GET", url, nil)
+	r.Header.Set("Range", "bytes=0-3")
+	w := httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Code, 206)
+	testkit.Equal(t, w.Header().Get("Accept-Ranges"), "bytes")
+	testkit.Equal(t, w.Body.Bytes(), file.Content[0:4])
+}
+
+func TestHead(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	r := httptest.NewRequest("HEAD", url, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	f.Serve(url, w, r)
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Body.Len(), 0)
+	testkit.Equal(t, w.Header().Get("Content-Type"), file.ContentType)
+	testkit.Equal(t, w.Header().Get("Content-Encoding"), "gzip")
+	testkit.Equal(t, w.Header().Get("Content-Length"), strconv.Itoa(len(file.ContentGZipped)))
+	testkit.Equal(t, w.Header().Get("ETag"), "\""+file.HashString+"\"")
+	testkit.Equal(t, w.Header().Get("Vary"), "Accept-Encoding")
+
+	w = httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Header().Get("Vary"), "Accept-Encoding")
+	testkit.Equal(t, w.Header().Get("Content-Length"), strconv.Itoa(len(file.Content)))
+}
+
+func TestCachePolicy(t *testing.T) {
+	f := NewAssets("/a/")
+	f.CachePolicy.Extensions = map[string]CachePolicy{
+		".png": {MaxAge: time.Hour},
+	}
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	serve := func(virtualPath string) http.Header {
+		url, err := f.GetUrl(virtualPath)
+		testkit.NoError(t, err)
+		w := httptest.NewRecorder()
+		f.Serve(url, w, nil)
+		return w.Header()
+	}
+
+	testkit.Equal(t, serve("/css/test.css").Get("Cache-Control"), "public, max-age=31556926, immutable")
+	testkit.Equal(t, serve("/images/red.png").Get("Cache-Control"), "public, max-age=3600")
+
+	f.CachePolicy = CachePolicy{MaxAge: time.Minute, SMaxAge: time.Hour, StaleWhileRevalidate: time.Second * 30, StaleIfError: time.Hour * 24}
+	testkit.Equal(t, serve("/css/test.css").Get("Cache-Control"), "public, max-age=60, s-maxage=3600, stale-while-revalidate=30, stale-if-error=86400")
+
+	f.CachePolicy = NoCachePolicy()
+	testkit.Equal(t, serve("/css/test.css").Get("Cache-Control"), "no-cache")
+}
+
+func TestCORS(t *testing.T) {
+	f := NewAssets("/a/")
+	f.CORS = []CORSConfig{
+		{Prefix: "/css/", AllowedOrigins: []string{"*"}, MaxAge: time.Hour},
+		{Prefix: "/templates/", AllowedOrigins: []string{"https://example.com"}, AllowCredentials: true},
+	}
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	serve := func(method string, virtualPath string, origin string) *httptest.ResponseRecorder {
+		url, err := f.GetUrl(virtualPath)
+		testkit.NoError(t, err)
+		r := httptest.NewRequest(method, url, nil)
+		r.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		w := httptest.NewRecorder()
+		f.Serve(url, w, r)
+		return w
+	}
+
+	w := serve("GET", "/css/test.css", "https://other.com")
+	testkit.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "*")
+
+	w = serve("OPTIONS", "/css/test.css", "https://other.com")
+	testkit.Equal(t, w.Code, 204)
+	testkit.Equal(t, w.Header().Get("Access-Control-Max-Age"), "3600")
+
+	w = serve("GET", "/templates/simple.txt", "https://example.com")
+	testkit.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "https://example.com")
+	testkit.Equal(t, w.Header().Get("Access-Control-Allow-Credentials"), "true")
+
+	w = serve("GET", "/templates/simple.txt", "https://other.com")
+	testkit.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+
+	// responses without an Origin vary by it too, so caches keep them apart
+	w = serve("GET", "/templates/simple.txt", "")
+	testkit.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+	testkit.Assert(t, strings.Contains(strings.Join(w.Header()["Vary"], ","), "Origin"))
+	w = serve("GET", "/css/test.css", "")
+	testkit.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "*")
+}
+
+func TestEarlyHints(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+	f.DeclareDependencies("/templates/master.tmpl", "/css/test.css")
+	f.DeclareDependencies("/templates/index.tmpl", "/images/red.png", "/css/test.css")
+
+	dependencies := f.TemplateDependencies([]string{"/templates/index.tmpl", "/templates/master.tmpl"})
+	testkit.Equal(t, dependencies, []string{"/images/red.png", "/css/test.css"})
+
+	cssURL, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.EarlyHints(w, "/css/test.css"))
+	testkit.Equal(t, w.Header().Get("Link"), "<"+cssURL+">; rel=preload; as=style")
+
+	// plain http/1 writers don't support push
+	testkit.NoError(t, f.Push(w, dependencies...))
+}
+
+func TestServeHTTP(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/a/", f)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	testkit.Equal(t, w.Code, 200)
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/b/"+url[3:], nil))
+	testkit.Equal(t, w.Code, 404)
+}
+
+func TestErrorHandlers(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	f.ErrorRenderer = JSONErrorRenderer
+	w := httptest.NewRecorder()
+	f.Serve("/a/bad", w, nil)
+	testkit.Equal(t, w.Code, 404)
+	testkit.Equal(t, w.Body.String(), "{\"status\":404,\"error\":\"404 - File not found\"}\n")
+
+	w = httptest.NewRecorder()
+	testkit.Error(t, f.RenderTemplate([]string{"/templates/unknown.tmpl"}, w, nil))
+	testkit.Equal(t, w.Code, 500)
+	testkit.Equal(t, w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+
+	f.ErrorRenderer = TemplateErrorRenderer(f, []string{"/templates/simple.txt"})
+	w = httptest.NewRecorder()
+	f.Serve("/a/bad", w, nil)
+	testkit.Equal(t, w.Code, 404)
+	testkit.Equal(t, w.Body.String(), "simple.txt")
+
+	f.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("custom:" + r.URL.Path))
+	})
+	w = httptest.NewRecorder()
+	f.Serve("/a/bad", w, nil)
+	testkit.Equal(t, w.Body.String(), "custom:/a/bad")
+}
+
+func TestServeVirtual(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	w := httptest.NewRecorder()
+	f.ServeVirtual(w, httptest.NewRequest("GET", "/css/test.css", nil))
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), "no-cache")
+	testkit.Equal(t, w.Header().Get("Content-Type"), "text/css; charset=utf-8")
+
+	w = httptest.NewRecorder()
+	f.ServeVirtual(w, httptest.NewRequest("GET", "/css/unknown.css", nil))
+	testkit.Equal(t, w.Code, 404)
+}
+
+func TestDownload(t *testing.T) {
+	f := NewAssets("/a/")
+	f.DownloadPrefixes = []string{"/templates/"}
+	f.AddFileWithOptions("testassets/templates/simple.txt", "/reports/test.css", FileOptions{Download: true, Filename: "report 1.css"})
+	f.AddFile("testassets/templates/simple.txt", "/templates/simple.txt")
+
+	serve := func(virtualPath string) http.Header {
+		w := httptest.NewRecorder()
+		f.ServeVirtual(w, httptest.NewRequest("GET", virtualPath, nil))
+		return w.Header()
+	}
+
+	testkit.Equal(t, serve("/reports/test.css").Get("Content-Disposition"), "attachment; filename=\"report 1.css\"")
+	testkit.Equal(t, serve("/templates/simple.txt").Get("Content-Disposition"), "attachment; filename=simple.txt")
+}
+
+func TestServeVirtualIndex(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddFile("testassets/templates/simple.txt", "/docs/index.html")
+
+	w := httptest.NewRecorder()
+	f.ServeVirtual(w, httptest.NewRequest("GET", "/docs/", nil))
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Body.String(), "simple.txt")
+
+	w = httptest.NewRecorder()
+	f.ServeVirtual(w, httptest.NewRequest("GET", "/docs?page=2", nil))
+	testkit.Equal(t, w.Code, 301)
+	testkit.Equal(t, w.Header().Get("Location"), "/docs/?page=2")
+
+	w = httptest.NewRecorder()
+	f.ServeVirtual(w, httptest.NewRequest("GET", "/other/", nil))
+	testkit.Equal(t, w.Code, 404)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	f := NewAssets("/a/")
+	f.SecurityHeaders = SecurityHeaders{NoSniff: true, CrossOriginResourcePolicy: "cross-origin", TimingAllowOrigin: "*"}
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+
+	w := httptest.NewRecorder()
+	f.ServeVirtual(w, httptest.NewRequest("GET", "/simple.txt", nil))
+	testkit.Equal(t, w.Header().Get("X-Content-Type-Options"), "nosniff")
+	testkit.Equal(t, w.Header().Get("Cross-Origin-Resource-Policy"), "cross-origin")
+	testkit.Equal(t, w.Header().Get("Timing-Allow-Origin"), "*")
+}
+
+func TestHashFunc(t *testing.T) {
+	f := NewAssets("/a/")
+	f.HashFunc = sha256.New
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+
+	file, err := f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, file.HashString, "1a2b9314a8c1f5b6ab3455f5e37fa6e8f6ca6f2c3cbf6950a613ef8c24b4c6e2")
+}
+
+func TestHashLength(t *testing.T) {
+	f := NewAssets("/a/")
+	f.HashLength = 12
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, url, "/a/"+file.HashString[:12])
+
+	w := httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Code, 200)
+
+	// with a single character, several of the test assets collide.
+	f = NewAssets("/a/")
+	f.HashLength = 1
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+	_, err = f.BuildAll(context.Background())
+	testkit.Error(t, err)
+}
+
+func TestNamedHashURLs(t *testing.T) {
+	f := NewAssets("/a/")
+	f.URLFormat = URLNamedHash
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, url, "/a/test."+file.HashString+".css")
+
+	w := httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Body.Bytes(), file.Content)
+
+	// the plain format keeps working
+	w = httptest.NewRecorder()
+	f.Serve("/a/"+file.HashString, w, nil)
+	testkit.Equal(t, w.Code, 200)
+
+	// files without an extension are served too
+	f.AddContent("/LICENSE", []byte("MIT"), FileOptions{})
+	license, err := f.Get("/LICENSE")
+	testkit.NoError(t, err)
+	url, err = f.GetUrl("/LICENSE")
+	testkit.NoError(t, err)
+	testkit.Equal(t, url, "/a/LICENSE."+license.HashString)
+	w = httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Body.String(), "MIT")
+}
+
+func TestQueryURLs(t *testing.T) {
+	f := NewAssets("/a/")
+	f.URLFormat = URLQuery
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, url, "/a/css/test.css?v="+file.HashString)
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), "public, max-age=31556926, immutable")
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/a/css/test.css?v=old", nil))
+	testkit.Equal(t, w.Code, 200)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), "no-cache")
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/a/css/unknown.css", nil))
+	testkit.Equal(t, w.Code, 404)
+}
+
+func TestIntegrity(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	file, err := f.Get("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.HasPrefix(file.Integrity, "sha384-"))
+
+	tag, err := f.StylesheetTag("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(tag), `<link rel="stylesheet" href="/a/`+file.HashString+`" integrity="`+file.Integrity+`" crossorigin="anonymous">`)
+}
+
+func TestManifest(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	data, err := f.Manifest()
+	testkit.NoError(t, err)
+	manifest, err := ParseManifest(data)
+	testkit.NoError(t, err)
+
+	url, err := f.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, manifest["/css/test.css"].URL, url)
+	testkit.Equal(t, manifest["/css/test.css"].ContentType, "text/css; charset=utf-8")
+
+	// a process without the files resolves urls from the manifest
+	other := NewAssets("/a/")
+	other.LoadManifest(manifest)
+	otherURL, err := other.GetUrl("/css/test.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, otherURL, url)
+	_, err = other.GetUrl("/css/unknown.css")
+	testkit.Error(t, err)
+}
+
+func TestBuildID(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+	id, err := f.BuildID()
+	testkit.NoError(t, err)
+
+	other := NewAssets("/a/")
+	testkit.NoError(t, other.AddDirectory("testassets", "/"))
+	otherID, err := other.BuildID()
+	testkit.NoError(t, err)
+	testkit.Equal(t, otherID, id)
+
+	other.AddFile("testassets/templates/simple.txt", "/simple.txt")
+	otherID, err = other.BuildID()
+	testkit.NoError(t, err)
+	testkit.Assert(t, otherID != id)
+}
+
+func TestDeduplicate(t *testing.T) {
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory("testassets", "/"))
+
+	// testassets contains two byte-identical copies of red.png
+	images, err := f.Get("/images/red.png")
+	testkit.NoError(t, err)
+	css, err := f.Get("/css/red.png")
+	testkit.NoError(t, err)
+	testkit.Assert(t, images == css)
+
+	// different options keep the files apart
+	f.AddFileWithOptions("testassets/images/red.png", "/download/red.png", FileOptions{Download: true})
+	download, err := f.Get("/download/red.png")
+	testkit.NoError(t, err)
+	testkit.Assert(t, download != images)
+
+	// and so do different content types, each served with its own
+	f.AddContent("/plain.txt", []byte("same"), FileOptions{})
+	f.AddContent("/data.json", []byte("same"), FileOptions{ContentType: "application/json"})
+	plainURL, err := f.GetUrl("/plain.txt")
+	testkit.NoError(t, err)
+	dataURL, err := f.GetUrl("/data.json")
+	testkit.NoError(t, err)
+	testkit.Assert(t, plainURL != dataURL)
+	for url, contentType := range map[string]string{plainURL: "text/plain; charset=utf-8", dataURL: "application/json"} {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		testkit.Equal(t, w.Code, 200)
+		testkit.Equal(t, w.Header().Get("Content-Type"), contentType)
+	}
+
+	// urls of superseded content stop resolving
+	first, err := f.GetUrl("/plain.txt")
+	testkit.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		f.AddContent("/plain.txt", []byte("edit "+strconv.Itoa(i)), FileOptions{})
+		_, err := f.Get("/plain.txt")
+		testkit.NoError(t, err)
+	}
+	testkit.Assert(t, len(f.byChecksum) <= 2*len(f.entries)+16)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", first, nil))
+	testkit.Equal(t, w.Code, 404)
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", dataURL, nil))
+	testkit.Equal(t, w.Code, 200)
+}
+
+func TestPreprocessorGlob(t *testing.T) {
+	f := NewAssets("/a/")
+	suffix := func(s string) Preprocessor {
+		return func(assets *Assets, path string, content []byte) ([]byte, error) {
+			return append(content, s...), nil
+		}
+	}
+	f.AddPreprocessor(".txt", suffix("-ext"))
+	f.AddPreprocessorGlob("/vendor/**", suffix("-glob"))
+	f.AddPreprocessorPrefix("/vendor/lib/", suffix("-prefix"))
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+	f.AddFile("testassets/templates/simple.txt", "/vendor/lib/simple.txt")
+
+	file, err := f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "simple.txt-ext")
+
+	file, err = f.Get("/vendor/lib/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "simple.txt-ext-glob-prefix")
+
+	f.ClearPreprocessorsGlob("/vendor/**")
+	f.AddFile("testassets/templates/simple.txt", "/vendor/lib/other.txt")
+	file, err = f.Get("/vendor/lib/other.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "simple.txt-ext-prefix")
+}
+
+func TestPreprocessorStages(t *testing.T) {
+	f := NewAssets("/a/")
+	suffix := func(s string) Preprocessor {
+		return func(assets *Assets, path string, content []byte) ([]byte, error) {
+			return append(content, s...), nil
+		}
+	}
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", Stage: StageMinify, Processor: suffix("-minify")})
+	f.AddPreprocessor(".txt", suffix("-transform"))
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", Stage: StageResolve, Processor: suffix("-resolve")})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", Stage: StageTransform + 1, Processor: suffix("-late")})
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+
+	file, err := f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "simple.txt-resolve-transform-late-minify")
+}
+
+func TestExecPreprocessor(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddPreprocessorPrefix("/upper/", ExecPreprocessor("tr", "a-z", "A-Z"))
+	f.AddPreprocessorPrefix("/bad/", ExecPreprocessor("sh", "-c", "echo broken {path} >&2; exit 1"))
+	f.AddPreprocessorPrefix("/slow/", ExecPreprocessorTimeout(time.Millisecond*10, "sleep", "5"))
+	f.AddFile("testassets/templates/simple.txt", "/upper/simple.txt")
+	f.AddFile("testassets/templates/simple.txt", "/bad/simple.txt")
+	f.AddFile("testassets/templates/simple.txt", "/slow/simple.txt")
+
+	file, err := f.Get("/upper/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "SIMPLE.TXT")
+
+	_, err = f.Get("/bad/simple.txt")
+	testkit.Error(t, err)
+	testkit.Assert(t, strings.Contains(err.Error(), "broken /bad/simple.txt"))
+
+	_, err = f.Get("/slow/simple.txt")
+	testkit.Error(t, err)
+	testkit.Assert(t, strings.Contains(err.Error(), "timed out"))
+}
+
+func TestScssPreprocessor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testkit.NoError(t, os.MkdirAll(filepath.Join(dir, "scss", "mixins"), 0755))
+	write := func(name string, content string) {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("scss/site.scss", "@import \"vars\", 'mixins';\n@import \"https://example.com/fonts.css\";\nbody { color: $red; }\n")
+	write("scss/_vars.scss", "$red: #f00;\n")
+	write("scss/mixins/_index.scss", "@import \"../vars\";\n")
+	write("scss/loop.scss", "@import \"loop\";\n")
+
+	f := NewAssets("/a/")
+	f.AddScssPreprocessor("cat")
+	testkit.NoError(t, f.AddDirectory(dir, "/"))
+
+	file, err := f.Get("/scss/site.scss")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "$red: #f00;\n\n$red: #f00;\n\n\n\n\n@import \"https://example.com/fonts.css\";\nbody { color: $red; }\n")
+	testkit.Equal(t, file.ContentType, "text/css; charset=utf-8")
+	testkit.Equal(t, f.Sources("/scss/site.scss"), []string{"/scss/_vars.scss", "/scss/mixins/_index.scss", "/scss/_vars.scss"})
+
+	// partials are left alone, cycles are reported
+	partial, err := f.Get("/scss/_vars.scss")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(partial.Content), "$red: #f00;\n")
+	_, err = f.Get("/scss/loop.scss")
+	testkit.Equal(t, err.Error(), "/scss/loop.scss: import cycle through /scss/loop.scss")
+
+	// only @import is resolved
+	f.AddContent("/scss/use.scss", []byte("@use \"sass:math\";\n@use \"vars\";\n"), FileOptions{})
+	_, err = f.Get("/scss/use.scss")
+	testkit.Equal(t, err.Error(), `/scss/use.scss: @use "vars" is not supported, use @import`)
+
+	// re-adding an import reloads the files that use it
+	write("scss/_blue.scss", "$red: #00f;\n")
+	f.AddFile(filepath.Join(dir, "scss/_blue.scss"), "/scss/_vars.scss")
+	file, err = f.Get("/scss/site.scss")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(string(file.Content), "#00f"))
+
+	// a missing compiler is named in the error
+	f = NewAssets("/a/")
+	f.AddScssPreprocessor("sitekit-missing-sass")
+	f.AddContent("/scss/site.scss", []byte("body { color: red; }\n"), FileOptions{})
+	_, err = f.Get("/scss/site.scss")
+	testkit.Assert(t, err != nil && strings.Contains(err.Error(), `scss compiler "sitekit-missing-sass" not found`))
+}
+
+func TestScssPreprocessorSass(t *testing.T) {
+	if _, err := exec.LookPath("sass"); err != nil {
+		t.Skip("sass is not installed")
+	}
+
+	f := NewAssets("/a/")
+	f.AddScssPreprocessor("")
+	f.AddContent("/scss/site.scss", []byte("$red: #f00;\nbody { a { color: $red; } }\n"), FileOptions{})
+	file, err := f.Get("/scss/site.scss")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(string(file.Content), "body a"))
+	testkit.Assert(t, !strings.Contains(string(file.Content), "$red"))
+}
+
+func TestPostCSSPreprocessor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+
+	f := NewAssets("/a/")
+	f.Cache = NewDiskCache(filepath.Join(dir, "cache"), "1")
+	f.AddPostCSSPreprocessor(PostCSSConfig{
+		Command: "sh",
+		Args:    []string{"-c", "echo {path} >> " + runs + "; tr a-z A-Z; echo {path}"},
+		Workers: 1,
+	})
+	source := filepath.Join(dir, "site.css")
+	testkit.NoError(t, ioutil.WriteFile(source, []byte("simple.txt"), 0644))
+	f.AddFile(source, "/one.css")
+	f.AddFile(source, "/two.css")
+
+	// the output of one file is not reused for another
+	one, err := f.Get("/one.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(one.Content), "SIMPLE.TXT/one.css\n")
+	two, err := f.Get("/two.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(two.Content), "SIMPLE.TXT/two.css\n")
+
+	// unchanged input doesn't run postcss again
+	f.AddFile(source, "/one.css")
+	_, err = f.Get("/one.css")
+	testkit.NoError(t, err)
+	ran, err := ioutil.ReadFile(runs)
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(ran), "/one.css\n/two.css\n")
+}
+
+func TestProductionCSSMinify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "site.css")
+	testkit.NoError(t, ioutil.WriteFile(source, []byte("\n  body { color: red; }\n\n"), 0644))
+
+	development := NewAssets("/a/")
+	development.AddFile(source, "/site.css")
+	file, err := development.Get("/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "\n  body { color: red; }\n\n")
+
+	production := NewAssets("/a/")
+	production.Mode = ModeProduction
+	production.AddFile(source, "/site.css")
+	file, err = production.Get("/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "body{color:red}")
+}
+
+func TestHTMLMinify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	page := "<p>\n  <!-- note -->\n  <b>hello</b>\n</p>\n"
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "page.html"), []byte(page), 0644))
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "page.tmpl"), []byte(page), 0644))
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pre.tmpl"), []byte(page), 0644))
+
+	f := NewAssets("/a/")
+	f.AddHTMLMinifyPreprocessor(HTMLMinifyOptions{})
+	testkit.NoError(t, f.AddDirectory(dir, "/"))
+
+	file, err := f.Get("/page.html")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "<p><b>hello</b></p>")
+
+	// templates are left alone unless configured (html/template drops comments itself)
+	rendered := "<p>\n  \n  <b>hello</b>\n</p>\n"
+	output, err := f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, rendered)
+
+	f.MinifyTemplates = &HTMLMinifyOptions{}
+	f.SetTemplateMinify("/pre.tmpl", nil)
+	output, err = f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<p><b>hello</b></p>")
+	output, err = f.RenderTemplateString([]string{"/pre.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, rendered)
+}
+
+func TestAttachSourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "site.css")
+	testkit.NoError(t, ioutil.WriteFile(source, []byte("body{}\n/*# sourceMappingURL=stale.map */\n"), 0644))
+
+	f := NewAssets("/a/")
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageCompile, Processor: func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return assets.AttachSourceMap(path, content, []byte(`{"version":3}`))
+	}})
+	f.AddFile(source, "/css/site.css")
+
+	file, err := f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	mapURL, err := f.GetUrl("/css/site.css.map")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "body{}\n/*# sourceMappingURL="+mapURL+" */\n")
+
+	w := httptest.NewRecorder()
+	f.Serve(mapURL, w, nil)
+	testkit.Equal(t, w.Code, http.StatusOK)
+	testkit.Equal(t, w.Header().Get("Content-Type"), "application/json")
+	testkit.Equal(t, w.Body.String(), `{"version":3}`)
+}
+
+func TestSourceMapPolicy(t *testing.T) {
+	f := NewAssets("/a/")
+	f.Mode = ModeProduction
+	f.AddContent("/js/app.js", []byte("app()\n//# sourceMappingURL=app.js.map\n"), FileOptions{})
+	f.AddContent("/js/app.js.map", []byte(`{"version":3}`), FileOptions{ContentType: "application/json"})
+	mapURL, err := f.GetUrl("/js/app.js.map")
+	testkit.NoError(t, err)
+	serve := func(header string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", mapURL, nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		f.Serve(mapURL, w, r)
+		return w
+	}
+	testkit.Equal(t, serve("").Code, http.StatusOK)
+
+	f.SourceMaps = SourceMapsPrivate
+	f.SourceMapAuth = func(r *http.Request) bool { return r.Header.Get("Authorization") == "secret" }
+	testkit.Equal(t, serve("").Code, http.StatusNotFound)
+	w := serve("secret")
+	testkit.Equal(t, w.Code, http.StatusOK)
+	testkit.Assert(t, strings.HasPrefix(w.Header().Get("Cache-Control"), "private"))
+
+	f.SourceMaps = SourceMapsStrip
+	f.AddContent("/js/app.js", []byte("app()\n//# sourceMappingURL=app.js.map\n"), FileOptions{})
+	file, err := f.Get("/js/app.js")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "app()\n")
+	testkit.Equal(t, serve("secret").Code, http.StatusNotFound)
+}
+
+func TestImageOptimize(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddImageOptimizePreprocessors(ImageOptimizeConfig{})
+	f.AddFile("testassets/images/red.png", "/images/red.png")
+	original, err := ioutil.ReadFile("testassets/images/red.png")
+	testkit.NoError(t, err)
+
+	file, err := f.Get("/images/red.png")
+	testkit.NoError(t, err)
+	testkit.Assert(t, len(file.Content) <= len(original))
+	img, err := png.Decode(bytes.NewReader(file.Content))
+	testkit.NoError(t, err)
+	expected, err := png.Decode(bytes.NewReader(original))
+	testkit.NoError(t, err)
+	testkit.Equal(t, img.Bounds(), expected.Bounds())
+	testkit.Equal(t, img.At(0, 0), expected.At(0, 0))
+
+	// jpeg metadata is stripped, the image data is kept
+	jpeg := []byte{0xff, 0xd8}
+	jpeg = append(jpeg, 0xff, 0xe0, 0x00, 0x04, 'J', 'F')           // APP0, kept
+	jpeg = append(jpeg, 0xff, 0xe1, 0x00, 0x06, 'E', 'x', 'i', 'f') // APP1, dropped
+	jpeg = append(jpeg, 0xff, 0xfe, 0x00, 0x04, 'h', 'i')           // COM, dropped
+	jpeg = append(jpeg, 0xff, 0xda, 0x01, 0x02, 0xff, 0xd9)
+	stripped, err := stripJPEGMetadata(jpeg)
+	testkit.NoError(t, err)
+	testkit.Equal(t, stripped, []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 'J', 'F', 0xff, 0xda, 0x01, 0x02, 0xff, 0xd9})
+
+	// except for the orientation
+	exif := []byte{'E', 'x', 'i', 'f', 0, 0, 'M', 'M', 0, 0x2a, 0, 0, 0, 8, 0, 2,
+		0x01, 0x0f, 0, 2, 0, 0, 0, 4, 'A', 'c', 'm', 0, // Make
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6, 0, 0, // Orientation
+		0, 0, 0, 0}
+	jpeg = append([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00, byte(len(exif) + 2)}, exif...)
+	jpeg = append(jpeg, 0xff, 0xda, 0x01, 0x02, 0xff, 0xd9)
+	stripped, err = stripJPEGMetadata(jpeg)
+	testkit.NoError(t, err)
+	testkit.Equal(t, len(stripped), len(jpeg)-len(exif)+32)
+	testkit.Equal(t, exifOrientation(stripped[6:38]), uint16(6))
+
+	// png color chunks are kept
+	var encoded bytes.Buffer
+	testkit.NoError(t, png.Encode(&encoded, expected))
+	gamma := []byte{0, 0, 0, 4, 'g', 'A', 'M', 'A', 0, 0, 0xb1, 0x8f, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(gamma[12:], crc32.ChecksumIEEE(gamma[4:12]))
+	withGamma := append(append(append([]byte(nil), encoded.Bytes()[:33]...), gamma...), encoded.Bytes()[33:]...)
+	optimized, err := optimizePNG(withGamma)
+	testkit.NoError(t, err)
+	testkit.Assert(t, bytes.Contains(optimized, gamma))
+	_, err = png.Decode(bytes.NewReader(optimized))
+	testkit.NoError(t, err)
+
+	// animated pngs are left alone
+	chunk := func(kind string, data []byte) []byte {
+		result := make([]byte, 4, 12+len(data))
+		binary.BigEndian.PutUint32(result, uint32(len(data)))
+		result = append(append(result, kind...), data...)
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(result[4:]))
+		return append(result, crc...)
+	}
+	frame := func(sequence byte) []byte {
+		return []byte{0, 0, 0, sequence, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 10, 0, 0}
+	}
+	frames := append([]byte(nil), encoded.Bytes()[:33]...)
+	frames = append(frames, chunk("acTL", []byte{0, 0, 0, 2, 0, 0, 0, 0})...)
+	frames = append(frames, chunk("fcTL", frame(0))...)
+	frames = append(frames, encoded.Bytes()[33:len(encoded.Bytes())-12]...) // IDAT
+	frames = append(frames, chunk("fcTL", frame(1))...)
+	frames = append(frames, chunk("fdAT", append([]byte{0, 0, 0, 2}, bytes.Repeat([]byte{0}, 64)...))...)
+	frames = append(frames, chunk("IEND", nil)...)
+	f.AddContent("/images/animated.png", frames, FileOptions{})
+	file, err = f.Get("/images/animated.png")
+	testkit.NoError(t, err)
+	testkit.Equal(t, file.Content, frames)
+
+	// images over the size limit are left alone
+	limited := ImageOptimizePreprocessor(ImageOptimizeConfig{MaxSize: 1})
+	content, err := limited(f, "/images/red.png", original)
+	testkit.NoError(t, err)
+	testkit.Equal(t, content, original)
+}
+
+func TestImageVariants(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddImageVariants(ImageVariantConfig{WebP: []string{"head", "-c", "10"}})
+	f.AddFile("testassets/images/red.png", "/images/red.png")
+
+	picture, err := f.PictureTag("/images/red.png", "a <red> dot")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/images/red.png")
+	testkit.NoError(t, err)
+	webpURL, err := f.GetUrl("/images/red.png.webp")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(picture), `<picture><source type="image/webp" srcset="`+webpURL+`"><img src="`+url+`" alt="a &lt;red&gt; dot"></picture>`)
+
+	request := httptest.NewRequest("GET", url, nil)
+	request.Header.Set("Accept", "image/avif,image/webp,*/*")
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, request)
+	testkit.Equal(t, w.Header().Get("Content-Type"), "image/webp")
+	testkit.Equal(t, w.Body.Len(), 10)
+	testkit.Assert(t, strings.Contains(strings.Join(w.Header()["Vary"], ","), "Accept"))
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	testkit.Equal(t, w.Header().Get("Content-Type"), "image/png")
+
+	// refused formats are not served
+	request = httptest.NewRequest("GET", url, nil)
+	request.Header.Set("Accept", "image/webp;q=0,image/*")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, request)
+	testkit.Equal(t, w.Header().Get("Content-Type"), "image/png")
+
+	// the variants of a changed image are not served for the old version
+	old, err := f.Get("/images/red.png")
+	testkit.NoError(t, err)
+	content, err := ioutil.ReadFile("testassets/images/red.png")
+	testkit.NoError(t, err)
+	f.AddContent("/images/red.png", append(content, "changed"...), FileOptions{})
+	current, err := f.Get("/images/red.png")
+	testkit.NoError(t, err)
+	request = httptest.NewRequest("GET", url, nil)
+	request.Header.Set("Accept", "image/webp")
+	variant, _ := f.imageVariant(request, old)
+	testkit.Assert(t, variant == nil)
+	variant, _ = f.imageVariant(request, current)
+	testkit.Assert(t, variant != nil)
+}
+
+func TestResponsive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	testkit.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 100, 50))))
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hero.png"), buf.Bytes(), 0644))
+
+	f := NewAssets("/a/")
+	f.AddFile(filepath.Join(dir, "hero.png"), "/images/hero.png")
+	f.Responsive("/images/hero.png", 50, 25, 200)
+
+	srcset, err := f.SrcSet("/images/hero.png")
+	testkit.NoError(t, err)
+	url25, _ := f.GetUrl("/images/hero-25w.png")
+	url50, _ := f.GetUrl("/images/hero-50w.png")
+	url100, _ := f.GetUrl("/images/hero.png")
+	testkit.Equal(t, string(srcset), `srcset="`+url25+` 25w, `+url50+` 50w, `+url100+` 100w"`)
+
+	file, err := f.Get("/images/hero-25w.png")
+	testkit.NoError(t, err)
+	resized, err := png.Decode(bytes.NewReader(file.Content))
+	testkit.NoError(t, err)
+	testkit.Equal(t, resized.Bounds(), image.Rect(0, 0, 25, 12))
+	testkit.Equal(t, file.ContentType, "image/png")
+}
+
+func TestInlineSVG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	icon := "<?xml version=\"1.0\"?>\n<!DOCTYPE svg>\n<svg class=\"icon\" viewBox=\"0 0 1 1\"><path d=\"M0 0\"/></svg>"
+	testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, "icon.svg"), []byte(icon), 0644))
+
+	f := NewAssets("/a/")
+	f.AddSVGMinifyPreprocessor()
+	f.AddFile(filepath.Join(dir, "icon.svg"), "/icons/icon.svg")
+
+	markup, err := f.InlineSVG("/icons/icon.svg", "class", "large", "aria-hidden", "true")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(markup), `<svg class="icon large" viewBox="0 0 1 1" aria-hidden="true"><path d="M0 0"/></svg>`)
+
+	_, err = f.InlineSVG("/icons/icon.svg", "class")
+	testkit.Error(t, err)
+}
+
+func TestInlineSmallAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "site.css")
+	testkit.NoError(t, ioutil.WriteFile(source, []byte("a{background:url(/images/red.png)}b{background:url(/simple.txt)}"), 0644))
+
+	f := NewAssets("/a/")
+	f.InlineMaxSize = 10
+	f.AddFile("testassets/images/red.png", "/images/red.png")
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+	f.AddFile(source, "/site.css")
+
+	file, err := f.Get("/site.css")
+	testkit.NoError(t, err)
+	redURL, err := f.GetUrl("/images/red.png")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "a{background:url("+redURL+")}b{background:url(data:text/plain;charset=utf-8;base64,c2ltcGxlLnR4dA==)}")
+
+	f.InlinePatterns = []string{"/images/*"}
+	f.AddFile(source, "/site.css")
+	file, err = f.Get("/site.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.HasPrefix(string(file.Content), "a{background:url(data:image/png;base64,"))
+}
+
+func TestURLRewritePreprocessors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddURLRewritePreprocessors()
+	f.AddFile(write("util.js", "export const x = 1;"), "/js/util.js")
+	f.AddFile(write("main.js", "import { x } from \"./util.js\";\nimport lodash from 'lodash';\nimport('../js/util.js?v=1');\n"), "/js/main.js")
+	f.AddFile(write("broken.js", "import \"./missing.js\";"), "/js/broken.js")
+	f.AddFile(write("index.html", `<script src="js/util.js"></script><a href="/about">x</a><a href="https://example.com/">y</a><a href="#top">z</a>`), "/index.html")
+
+	utilURL, err := f.GetUrl("/js/util.js")
+	testkit.NoError(t, err)
+	file, err := f.Get("/js/main.js")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "import { x } from \""+utilURL+"\";\nimport lodash from 'lodash';\nimport('"+utilURL+"?v=1');\n")
+
+	_, err = f.Get("/js/broken.js")
+	testkit.Error(t, err)
+
+	file, err = f.Get("/index.html")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), `<script src="`+utilURL+`"></script><a href="/about">x</a><a href="https://example.com/">y</a><a href="#top">z</a>`)
+}
+
+func TestMarkdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddMarkdownPreprocessor()
+	f.AddFile(write("post.md", "---\ntitle: \"Hello\"\nauthor: me\n---\n# Heading\n\nText.\n"), "/content/post.md")
+	f.AddFile(write("layout.tmpl", `{{$page := frontmatter .}}<title>{{$page.title}}</title>{{markdown .}}`), "/layout.tmpl")
+
+	frontmatter, err := f.Frontmatter("/content/post.md")
+	testkit.NoError(t, err)
+	testkit.Equal(t, frontmatter, map[string]string{"title": "Hello", "author": "me"})
+
+	file, err := f.Get("/content/post.md")
+	testkit.NoError(t, err)
+	testkit.Equal(t, file.ContentType, "text/html; charset=utf-8")
+
+	output, err := f.RenderTemplateString([]string{"/layout.tmpl"}, "/content/post.md")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<title>Hello</title>"+string(file.Content))
+	testkit.Assert(t, strings.Contains(output, "<h1>Heading</h1>"))
+}
+
+func TestTemplateExpansion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddTemplateExpansion(map[string]interface{}{"API": "https://api.example.com", "Beta": true})
+	f.AddFile("testassets/images/red.png", "/images/red.png")
+	f.AddFile(write("app.js", `fetch("{{.API}}/items");{{if .Beta}}beta();{{end}}load("{{asset "/images/red.png"}}");`), "/app.js")
+	f.AddFile(write("broken.js", `{{.Missing}}`), "/broken.js")
+
+	file, err := f.Get("/app.js")
+	testkit.NoError(t, err)
+	url, err := f.GetUrl("/images/red.png")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), `fetch("https://api.example.com/items");beta();load("`+url+`");`)
+
+	_, err = f.Get("/broken.js")
+	testkit.Error(t, err)
+}
+
+func TestPreprocessError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddPreprocessorPrefix("/fail/", func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return nil, errors.New("unexpected token on line 2, column 5")
+	})
+	f.AddFile(write("site.css", "a{}\nb{ !! }\nc{}\n"), "/fail/site.css")
+	f.AddFile(write("page.tmpl", "{{$first := 0 -}}\n{{index .Items 5}}\n"), "/page.tmpl")
+	f.AddFile(write("broken.tmpl", "<p>\n{{if}}\n</p>"), "/broken.tmpl")
+
+	_, err = f.Get("/fail/site.css")
+	preprocessErr, ok := err.(*PreprocessError)
+	testkit.Assert(t, ok)
+	testkit.Equal(t, preprocessErr.Path, "/fail/site.css")
+	testkit.Equal(t, preprocessErr.Line, 2)
+	testkit.Equal(t, preprocessErr.Column, 5)
+	testkit.Equal(t, preprocessErr.Snippet, "     1 | a{}\n>    2 | b{ !! }\n     3 | c{}\n     4 | \n")
+
+	_, err = f.RenderTemplateString([]string{"/broken.tmpl"}, nil)
+	preprocessErr, ok = err.(*PreprocessError)
+	testkit.Assert(t, ok)
+	testkit.Equal(t, preprocessErr.Line, 2)
+
+	w := httptest.NewRecorder()
+	f.ErrorRenderer = DevErrorRenderer
+	testkit.Error(t, f.RenderTemplate([]string{"/page.tmpl"}, w, map[string]interface{}{"Items": []int{}}))
+	testkit.Equal(t, w.Code, http.StatusInternalServerError)
+	testkit.Assert(t, strings.Contains(w.Body.String(), `<p class="location">/page.tmpl:2:`))
+	testkit.Assert(t, strings.Contains(w.Body.String(), "&gt;    2 | {{index .Items 5}}"))
+	testkit.Assert(t, strings.Contains(w.Body.String(), `<ol class="templates"><li>/page.tmpl</li></ol>`))
+	testkit.Assert(t, strings.Contains(w.Body.String(), "&#34;Items&#34;: []"))
+	var templateErr *TemplateError
+	testkit.Assert(t, errors.As(f.RenderTemplate([]string{"/broken.tmpl"}, httptest.NewRecorder(), nil), &templateErr))
+	testkit.Equal(t, templateErr.Name, "/broken.tmpl")
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+
+	load := func(version string) string {
+		f := NewAssets("/a/")
+		f.Cache = NewDiskCache(filepath.Join(dir, "cache"), version)
+		f.AddPreprocessorPrefix("/", ExecPreprocessor("sh", "-c", "echo run >> "+runs+"; tr a-z A-Z"))
+		f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+		file, err := f.Get("/simple.txt")
+		testkit.NoError(t, err)
+		return string(file.Content)
+	}
+
+	// a restart with the same version reuses the result
+	testkit.Equal(t, load("1"), "SIMPLE.TXT")
+	testkit.Equal(t, load("1"), "SIMPLE.TXT")
+	ran, err := ioutil.ReadFile(runs)
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(ran), "run\n")
+
+	testkit.Equal(t, load("2"), "SIMPLE.TXT")
+	ran, err = ioutil.ReadFile(runs)
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(ran), "run\nrun\n")
+}
+
+func TestSingleFlightLoad(t *testing.T) {
+	var runs int32
+	f := NewAssets("/a/")
+	f.AddPreprocessorPrefix("/", func(assets *Assets, path string, content []byte) ([]byte, error) {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(time.Millisecond * 10)
+		return content, nil
+	})
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+
+	files := make(chan *File, 10)
+	for i := 0; i < cap(files); i++ {
+		go func() {
+			file, err := f.Get("/simple.txt")
+			testkit.NoError(t, err)
+			files <- file
+		}()
+	}
+	first := <-files
+	for i := 1; i < cap(files); i++ {
+		testkit.Assert(t, <-files == first)
+	}
+	testkit.Equal(t, atomic.LoadInt32(&runs), int32(1))
+
+	// re-adding loads a new File and leaves the old one untouched
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+	file, err := f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Assert(t, file != first)
+	testkit.Equal(t, string(first.Content), "simple.txt")
+}
+
+func TestOnProcessed(t *testing.T) {
+	f := NewAssets("/a/")
+	processed := map[string]int{}
+	f.OnProcessed(func(path string, file *File) {
+		processed[path] = len(file.Content)
+	})
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+	f.AddFile("testassets/templates/simple.txt", "/copy.txt")
+
+	_, err := f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	_, err = f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	_, err = f.Get("/copy.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, processed, map[string]int{"/simple.txt": 10, "/copy.txt": 10})
+}
+
+func TestContextPreprocessor(t *testing.T) {
+	f := NewAssets("/a/")
+	f.Mode = ModeProduction
+	var seen PreprocessContext
+	f.AddContextPreprocessor(".txt", func(ctx *PreprocessContext, content []byte) ([]byte, error) {
+		seen = *ctx
+		ctx.AddDerived(".upper", bytes.ToUpper(content), FileOptions{ContentType: "text/plain"})
+		return content, nil
+	})
+	f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+
+	_, err := f.Get("/simple.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, seen.Path, "/simple.txt")
+	testkit.Equal(t, seen.SourcePath, "testassets/templates/simple.txt")
+	testkit.Equal(t, seen.ContentType, "text/plain; charset=utf-8")
+	testkit.Equal(t, seen.Mode, ModeProduction)
+
+	derived, err := f.Get("/simple.txt.upper")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(derived.Content), "SIMPLE.TXT")
+}
+
+func TestMode(t *testing.T) {
+	banner := DevelopmentOnly(func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return append([]byte("/* dev */"), content...), nil
+	})
+	load := func(mode Mode) (*Assets, *File) {
+		f := NewAssets("/a/")
+		f.Mode = mode
+		f.AddPreprocessorPrefix("/", banner)
+		f.AddFile("testassets/templates/simple.txt", "/simple.txt")
+		file, err := f.Get("/simple.txt")
+		testkit.NoError(t, err)
+		return f, file
+	}
+
+	f, file := load(ModeDevelopment)
+	testkit.Equal(t, string(file.Content), "/* dev */simple.txt")
+	url, err := f.GetUrl("/simple.txt")
+	testkit.NoError(t, err)
+	w := httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), NoCachePolicy().CacheControl())
+	testkit.Assert(t, f.templateFuncMap["development"].(func() bool)())
+	testkit.Assert(t, !f.templateFuncMap["production"].(func() bool)())
+
+	f, file = load(ModeProduction)
+	testkit.Equal(t, string(file.Content), "simple.txt")
+	url, err = f.GetUrl("/simple.txt")
+	testkit.NoError(t, err)
+	w = httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), DefaultCachePolicy().CacheControl())
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile("testassets/images/red.png", "/images/red.png")
+	f.AddFile(write("reset.css", "*{margin:0}"), "/css/reset.css")
+	f.AddFile(write("site.css", "a{background:url(../images/red.png)}"), "/css/site.css")
+	f.Bundle("/bundles/app.css", "/css/reset.css", "/css/site.css")
+
+	bundle, err := f.Get("/bundles/app.css")
+	testkit.NoError(t, err)
+	redURL, err := f.GetUrl("/images/red.png")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(bundle.Content), "*{margin:0}\na{background:url("+redURL+")}")
+	testkit.Equal(t, bundle.ContentType, "text/css; charset=utf-8")
+
+	tag, err := f.BundleTag("/bundles/app.css")
+	testkit.NoError(t, err)
+	expected, err := f.StylesheetTag("/bundles/app.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, tag, expected)
+
+	// re-adding a member rebuilds the bundle
+	f.AddFile(write("reset2.css", "*{padding:0}"), "/css/reset.css")
+	bundle, err = f.Get("/bundles/app.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.HasPrefix(string(bundle.Content), "*{padding:0}\n"))
+
+	f.Mode = ModeDevelopment
+	tag, err = f.BundleTag("/bundles/app.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, strings.Count(string(tag), "<link"), 2)
+
+	// bundles including themselves fail instead of waiting forever
+	f.Bundle("/bundles/self.css", "/css/reset.css", "/bundles/self.css")
+	_, err = f.Get("/bundles/self.css")
+	testkit.Assert(t, err != nil && strings.Contains(err.Error(), "/bundles/self.css -> /bundles/self.css"))
+	f.Bundle("/bundles/one.css", "/bundles/two.css")
+	f.Bundle("/bundles/two.css", "/css/site.css", "/bundles/one.css")
+	_, err = f.Get("/bundles/two.css")
+	testkit.Assert(t, err != nil && strings.Contains(err.Error(), "/bundles/two.css -> /bundles/one.css -> /bundles/two.css"))
+}
+
+func TestCSSImportFlattening(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	testkit.NoError(t, os.MkdirAll(filepath.Join(dir, "css", "parts"), 0755))
+	write := func(name string, content string) {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("css/site.css", "@charset \"utf-8\";\n@import url(\"parts/base.css\");\n@import \"parts/print.css\" print;\n@import url(https://fonts.example.com/font.css);\nbody{}\n")
+	write("css/parts/base.css", "@charset \"utf-8\";\na{background:url(../../red.png)}\n")
+	write("css/parts/print.css", "p{}\n")
+	write("css/loop.css", "@import \"loop2.css\";\n")
+	write("css/loop2.css", "@import \"loop.css\";\n")
+
+	f := NewAssets("/a/")
+	testkit.NoError(t, f.AddDirectory(dir, "/"))
+	f.AddFile("testassets/images/red.png", "/red.png")
+
+	file, err := f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	redURL, err := f.GetUrl("/red.png")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "@charset \"utf-8\";\n@import url(https://fonts.example.com/font.css);\na{background:url("+redURL+")}\n@media print {\np{}\n}\nbody{}\n")
+	testkit.Equal(t, f.Sources("/css/site.css"), []string{"/css/parts/base.css", "/css/parts/print.css"})
+
+	_, err = f.Get("/css/loop.css")
+	testkit.Error(t, err)
+	testkit.Assert(t, strings.Contains(err.Error(), "import cycle"))
+}
+
+func TestModuleBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("main.js", "import greet, { name as who } from \"./lib/greet\";\nimport * as util from \"/js/util.js\";\nconsole.log(greet(who), util.twice(2));\nimport(\"./lazy.js\").then(function (m) { m.run(); });\n"), "/js/main.js")
+	f.AddFile(write("greet.js", "export const name = \"world\";\nexport default function greet(who) { return \"hello \" + who; }\n"), "/js/lib/greet.js")
+	f.AddFile(write("util.js", "export function twice(n) { return n * 2; }\n"), "/js/util.js")
+	f.AddFile(write("lazy.js", "export { twice as run } from \"./util.js\";\n"), "/js/lazy.js")
+	f.ModuleBundle("/bundles/app.js", "/js/main.js", ModuleBundleOptions{})
+
+	bundle, err := f.Get("/bundles/app.js")
+	testkit.NoError(t, err)
+	content := string(bundle.Content)
+	testkit.Assert(t, !strings.Contains(content, "import "))
+	testkit.Assert(t, strings.Contains(content, `var __m1 = __import("/js/lib/greet.js"); var greet = __m1.default; var {name: who} = __m1;`))
+	testkit.Assert(t, strings.Contains(content, `__export(__exports, {"name": function () { return name; }, "default": function () { return greet; }});`))
+	testkit.Assert(t, strings.Contains(content, `Promise.resolve().then(function () { return __import("/js/lazy.js"); })`))
+	testkit.Equal(t, f.Sources("/bundles/app.js"), []string{"/js/util.js", "/js/lazy.js", "/js/lib/greet.js", "/js/main.js"})
+
+	// dynamic imports in their own chunk
+	f.ModuleBundle("/bundles/app.js", "/js/main.js", ModuleBundleOptions{ChunkDynamicImports: true})
+	bundle, err = f.Get("/bundles/app.js")
+	testkit.NoError(t, err)
+	chunkURL, err := f.GetUrl("/bundles/app/js/lazy.js")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(string(bundle.Content), `import("`+chunkURL+`").then(function (m) { return m.namespace; })`))
+	chunk, err := f.Get("/bundles/app/js/lazy.js")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.HasSuffix(string(chunk.Content), "export const namespace = __import(\"/js/lazy.js\");\n"))
+
+	// chunks of modules with the same name
+	testkit.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0755))
+	testkit.NoError(t, os.MkdirAll(filepath.Join(dir, "b"), 0755))
+	f.AddFile(write("a/util.js", "export const from = \"a\";\n"), "/js/a/util.js")
+	f.AddFile(write("b/util.js", "export const from = \"b\";\n"), "/js/b/util.js")
+	f.AddFile(write("split.js", "import(\"./a/util.js\");\nimport(\"./b/util.js\");\n"), "/js/split.js")
+	f.ModuleBundle("/bundles/split.js", "/js/split.js", ModuleBundleOptions{ChunkDynamicImports: true})
+	bundle, err = f.Get("/bundles/split.js")
+	testkit.NoError(t, err)
+	for _, name := range []string{"a", "b"} {
+		chunkURL, err := f.GetUrl("/bundles/split/js/" + name + "/util.js")
+		testkit.NoError(t, err)
+		testkit.Assert(t, strings.Contains(string(bundle.Content), `import("`+chunkURL+`")`))
+		chunk, err := f.Get("/bundles/split/js/" + name + "/util.js")
+		testkit.NoError(t, err)
+		testkit.Assert(t, strings.Contains(string(chunk.Content), `const from = "`+name+`";`))
+	}
+
+	// bare imports need a real bundler
+	f.AddFile(write("bare.js", "import React from \"react\";\n"), "/js/bare.js")
+	f.ModuleBundle("/bundles/bare.js", "/js/bare.js", ModuleBundleOptions{})
+	_, err = f.Get("/bundles/bare.js")
+	testkit.Assert(t, err != nil)
+}
+
+func TestCriticalCSS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("site.css", "@charset \"utf-8\";\n/* layout */\nbody{margin:0}\n.hero h1, .missing{font-size:2em}\n#footer{color:red}\na:hover{color:blue}\n@media (min-width:600px){.hero{padding:1em}.sidebar{float:left}}\n@font-face{font-family:x;src:local(x)}\n@keyframes spin{to{transform:rotate(1turn)}}\n"), "/css/site.css")
+	f.AddFile(write("page.tmpl", "<html><head>{{inlinecritical \"/css/site.css\"}}</head><body><div class=\"hero big\"><h1>Hi</h1><a href=\"/\">home</a></div></body></html>"), "/page.tmpl")
+
+	critical, err := f.CriticalCSS([]byte(`<div class="hero"><h1>Hi</h1></div>`), "/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, critical, "body{margin:0}.hero h1, .missing{font-size:2em}@media (min-width:600px){.hero{padding:1em}}@font-face{font-family:x;src:local(x)}")
+
+	url, err := f.GetUrl("/css/site.css")
+	testkit.NoError(t, err)
+	output, err := f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<html><head><style>body{margin:0}.hero h1, .missing{font-size:2em}a:hover{color:blue}@media (min-width:600px){.hero{padding:1em}}@font-face{font-family:x;src:local(x)}</style>"+
+		"<link rel=\"preload\" href=\""+url+"\" as=\"style\" onload=\"this.onload=null;this.rel='stylesheet'\"><noscript><link rel=\"stylesheet\" href=\""+url+"\"></noscript>"+
+		"</head><body><div class=\"hero big\"><h1>Hi</h1><a href=\"/\">home</a></div></body></html>")
+}
+
+func TestBundleManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("main.js", "import(\"./lazy.js\");\nimport(\"./lazy.js\");\n"), "/js/main.js")
+	f.AddFile(write("lazy.js", "export const x = 1;\n"), "/js/lazy.js")
+	f.AddFile(write("reset.css", "*{margin:0}"), "/css/reset.css")
+	f.ModuleBundle("/bundles/app.js", "/js/main.js", ModuleBundleOptions{ChunkDynamicImports: true})
+	f.Bundle("/bundles/app.css", "/css/reset.css")
+
+	manifest, err := f.BuildBundleManifest()
+	testkit.NoError(t, err)
+	testkit.Equal(t, len(manifest), 2)
+	appURL, err := f.GetUrl("/bundles/app.js")
+	testkit.NoError(t, err)
+	chunkURL, err := f.GetUrl("/bundles/app/js/lazy.js")
+	testkit.NoError(t, err)
+	testkit.Equal(t, manifest["/bundles/app.js"].URL, appURL)
+	testkit.Equal(t, manifest["/bundles/app.js"].Chunks, []string{chunkURL})
+	testkit.Equal(t, manifest["/bundles/app.js"].Dependencies, []string{"/js/main.js"})
+	testkit.Equal(t, manifest["/bundles/app.css"].Dependencies, []string{"/css/reset.css"})
+
+	data, err := f.BundleManifest()
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(string(data), `"chunks": [`))
+
+	_, err = f.GetBundle("/css/reset.css")
+	testkit.Assert(t, err != nil)
+
+	f.AddFile(write("page.tmpl", `{{with bundlemanifest "/bundles/app.js"}}<script src="{{.URL}}"></script>{{range .Chunks}}<link rel="prefetch" href="{{.}}">{{end}}{{end}}`), "/page.tmpl")
+	output, err := f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, `<script src="`+appURL+`"></script><link rel="prefetch" href="`+chunkURL+`">`)
+}
+
+func TestFontSubset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFontSubsetPreprocessors(FontSubsetConfig{
+		Templates: []string{"/templates/**"},
+		Command:   []string{"sh", "-c", "cat {input} > {output}; echo ' {unicodes}' >> {output}"},
+	})
+	f.AddFile(write("font.woff2", "font"), "/fonts/font.woff2")
+	f.AddFile(write("page.tmpl", "héllo → {{.}}"), "/templates/page.tmpl")
+
+	font, err := f.Get("/fonts/font.woff2")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(font.Content), "font U+0020-007E,U+00E9,U+2192\n")
+	testkit.Equal(t, f.Sources("/fonts/font.woff2"), []string{"/templates/page.tmpl"})
+
+	// changing a template subsets the font again
+	f.AddFile(write("page2.tmpl", "å"), "/templates/page.tmpl")
+	font, err = f.Get("/fonts/font.woff2")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(font.Content), "font U+0020-007E,U+00E5\n")
+}
+
+func TestSizeBudgets(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddContent("/bundles/app.js", []byte(strings.Repeat("var a = 1;\n", 100)), FileOptions{})
+	f.AddContent("/bundles/admin.js", []byte("var b = 2;\n"), FileOptions{})
+	f.Budgets = []SizeBudget{
+		{Pattern: "/bundles/*.js", MaxSize: 500},
+		{Pattern: "/bundles/app.js", MaxSize: 500, Gzipped: true},
+	}
+
+	report, err := f.CheckBudgets()
+	testkit.NoError(t, err)
+	testkit.Equal(t, len(report), 3)
+	exceeded := report.Exceeded()
+	testkit.Equal(t, len(exceeded), 1)
+	testkit.Equal(t, exceeded[0].Path, "/bundles/app.js")
+	testkit.Equal(t, exceeded[0].Size, 1100)
+	testkit.Assert(t, !exceeded[0].Gzipped)
+
+	// over budget only warns unless enforced
+	_, err = f.BuildAll(context.Background())
+	testkit.NoError(t, err)
+	f.EnforceBudgets = true
+	_, err = f.BuildAll(context.Background())
+	var budgetErr *BudgetError
+	testkit.Assert(t, errors.As(err, &budgetErr))
+	testkit.Equal(t, budgetErr.Report, exceeded)
+	testkit.Equal(t, err.Error(), "size budget exceeded: /bundles/app.js is 1100 bytes, budget 500")
+}
+
+func TestAssetPreload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddContent("/js/app.js", []byte("var a;"), FileOptions{})
+	f.AddContent("/js/later.js", []byte("var b;"), FileOptions{})
+	f.AddFile(write("layout.tmpl", `<head>{{preloadlinks}}</head>{{template "body" .}}`), "/layout.tmpl")
+	f.AddFile(write("page.tmpl", `{{define "body"}}{{if .}}{{asset_preload "/js/app.js"}}{{end}}{{asset_prefetch "/js/later.js"}}body{{end}}`), "/page.tmpl")
+	templates := []string{"/page.tmpl", "/layout.tmpl"}
+
+	preload, prefetch, err := f.TemplatePreloads(templates)
+	testkit.NoError(t, err)
+	testkit.Equal(t, preload, []string{"/js/app.js"})
+	testkit.Equal(t, prefetch, []string{"/js/later.js"})
+
+	appURL, err := f.GetUrl("/js/app.js")
+	testkit.NoError(t, err)
+	laterURL, err := f.GetUrl("/js/later.js")
+	testkit.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.RenderTemplate(templates, w, true))
+	testkit.Equal(t, w.Header()["Link"], []string{"<" + appURL + ">; rel=preload; as=script", "<" + laterURL + ">; rel=prefetch"})
+	testkit.Equal(t, w.Body.String(), `<head><link rel="preload" href="`+appURL+`" as="script"><link rel="prefetch" href="`+laterURL+`"></head>body`)
+
+	// paths must be known before rendering
+	f.AddFile(write("dynamic.tmpl", `{{asset_preload .}}`), "/dynamic.tmpl")
+	_, err = f.GetTemplate([]string{"/dynamic.tmpl"})
+	testkit.Assert(t, err != nil)
+}
+
+func TestReloadChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.Mode = ModeDevelopment
+	f.AddFile(write("reset.css", "*{margin:0}"), "/css/reset.css")
+	f.AddFile(write("site.css", "@import \"reset.css\";\na{color:red}"), "/css/site.css")
+	f.AddFile(write("page.tmpl", "v1"), "/page.tmpl")
+
+	site, err := f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(site.Content), "*{margin:0}\na{color:red}")
+	output, err := f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "v1")
+
+	// edits on disk show up without registering the files again
+	time.Sleep(10 * time.Millisecond)
+	write("reset.css", "*{padding:0}")
+	write("page.tmpl", "v2")
+	site, err = f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(site.Content), "*{padding:0}\na{color:red}")
+	output, err = f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "v2")
+
+	// other modes load files once
+	f.Mode = ModeProduction
+	write("reset.css", "*{border:0}")
+	site, err = f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(site.Content), "*{padding:0}\na{color:red}")
+}
+
+func TestRenderWithLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("base.tmpl", `<title>{{block "title" .}}Site{{end}}</title>{{block "content" .}}{{end}}`), "/layouts/base.tmpl")
+	f.AddFile(write("docs.tmpl", `{{define "content"}}<nav></nav>{{block "main" .}}{{end}}{{end}}`), "/layouts/docs.tmpl")
+	f.AddFile(write("page.tmpl", `{{define "title"}}{{.}}{{end}}{{define "main"}}<p>{{.}}</p>{{end}}`), "/page.tmpl")
+	f.ExtendLayout("/layouts/docs.tmpl", "/layouts/base.tmpl")
+
+	output, err := f.RenderWithLayoutString("/layouts/docs.tmpl", "/page.tmpl", "Hi")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<title>Hi</title><nav></nav><p>Hi</p>")
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.RenderWithLayout("/layouts/base.tmpl", "/page.tmpl", w, "Hi"))
+	testkit.Equal(t, w.Body.String(), "<title>Hi</title>")
+
+	output, err = f.RenderWithLayoutString("", "/layouts/base.tmpl", nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<title>Site</title>")
+}
+
+func TestTemplatePartials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.TemplatePartials("/templates/partials")
+	f.AddFile(write("nav.tmpl", `<nav>{{.}}</nav>`), "/templates/partials/nav.tmpl")
+	f.AddFile(write("footer.tmpl", `{{define "footer"}}<footer></footer>{{end}}`), "/templates/partials/footer.tmpl")
+	f.AddFile(write("page.tmpl", `{{template "/templates/partials/nav.tmpl" .}}{{template "footer"}}`), "/templates/page.tmpl")
+
+	output, err := f.RenderTemplateString([]string{"/templates/page.tmpl"}, "Hi")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<nav>Hi</nav><footer></footer>")
+
+	// partials added later are picked up
+	f.AddFile(write("header.tmpl", `{{define "header"}}<header></header>{{end}}`), "/templates/partials/header.tmpl")
+	f.AddFile(write("page2.tmpl", `{{template "header"}}`), "/templates/page.tmpl")
+	output, err = f.RenderTemplateString([]string{"/templates/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<header></header>")
+}
+
+func TestTextTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddContent("/js/app.js", []byte("var a;"), FileOptions{})
+	f.AddFile(write("email.txt", `Hi {{.}}, see {{asset "/js/app.js"}}`), "/email.txt")
+
+	url, err := f.GetUrl("/js/app.js")
+	testkit.NoError(t, err)
+	output, err := f.RenderTextTemplateString([]string{"/email.txt"}, "<Tom & Jerry>")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "Hi <Tom & Jerry>, see "+url)
+
+	// the html template of the same file escapes
+	output, err = f.RenderTemplateString([]string{"/email.txt"}, "<Tom & Jerry>")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "Hi &lt;Tom &amp; Jerry&gt;, see "+url)
+
+	var buf bytes.Buffer
+	f.AddFile(write("robots.txt", `User-agent: {{.}}`), "/robots.txt")
+	testkit.NoError(t, f.RenderTextTemplate([]string{"/robots.txt"}, &buf, "*"))
+	testkit.Equal(t, buf.String(), "User-agent: *")
+}
+
+func TestTemplateDelims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("app.tmpl", `<div id="app">{{ message }}</div>[[.]]`), "/app.tmpl")
+	_, err = f.RenderTemplateString([]string{"/app.tmpl"}, "server")
+	testkit.Assert(t, err != nil)
+
+	f.SetTemplateDelims("[[", "]]")
+	output, err := f.RenderTemplateString([]string{"/app.tmpl"}, "server")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, `<div id="app">{{ message }}</div>server`)
+	output, err = f.RenderTextTemplateString([]string{"/app.tmpl"}, "server")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, `<div id="app">{{ message }}</div>server`)
+}
+
+func TestStandardFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddStandardFuncs()
+	render := func(source string, data interface{}) string {
+		f.AddFile(write("page.tmpl", source), "/page.tmpl")
+		output, err := f.RenderTemplateString([]string{"/page.tmpl"}, data)
+		testkit.NoError(t, err)
+		return output
+	}
+
+	testkit.Equal(t, render(`{{formatdate "2006-01-02" .}}`, time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC)), "2020-03-04")
+	testkit.Equal(t, render(`{{number 1234567}} {{number -1234.5}} {{number 12}}`, nil), "1,234,567 -1,234.5 12")
+	testkit.Equal(t, render(`{{bytes 512}} {{bytes 1536}} {{bytes 1000000}} {{bytes 25000000}}`, nil), "512 B 1.5 kB 1 MB 25 MB")
+	testkit.Equal(t, render(`{{. | truncate 8}}|{{truncate 30 .}}`, "hello wonderful world"), "hello w…|hello wonderful world")
+	testkit.Equal(t, render(`{{markdownify .}}`, "# Title"), "<h1>Title</h1>\n")
+	testkit.Equal(t, render(`{{with dict "a" 1 "b" (list 2 3)}}{{.a}} {{index .b 1}}{{end}}`, nil), "1 3")
+	testkit.Equal(t, render(`{{default "none" .}}`, ""), "none")
+	testkit.Equal(t, render(`{{.Name | default "none"}}`, map[string]string{"Name": "x"}), "x")
+	testkit.Equal(t, render(`{{safehtml .}}<a {{safeattr "href=\"/\""}}></a>`, "<b>bold</b>"), `<b>bold</b><a href="/"></a>`)
+}
+
+func TestTranslations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.DefaultLocale = "en"
+	f.AddTranslations("/i18n")
+	f.AddFile(write("en.json", `{"hello": "Hello, %v!", "bye": "Bye", "items": {"zero": "No items", "one": "%d item", "other": "%d items"}}`), "/i18n/en.json")
+	f.AddFile(write("da.json", `{"hello": "Hej, %v!", "items": {"one": "%d ting", "other": "%d ting"}}`), "/i18n/da.json")
+	f.AddFile(write("page.tmpl", `{{t "hello" .}} {{t "items" 0}}, {{t "items" 1}}, {{t "items" 2}}. {{t "bye"}} {{t "missing"}}`), "/page.tmpl")
+
+	testkit.Equal(t, f.Locales(), []string{"da", "en"})
+	output, err := f.RenderTemplateString([]string{"/page.tmpl"}, "Bob")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "Hello, Bob! No items, 1 item, 2 items. Bye missing")
+	output, err = f.RenderLocalizedTemplateString([]string{"/page.tmpl"}, "da-DK", "Bob")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "Hej, Bob! 0 ting, 1 ting, 2 ting. Bye missing")
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Language", "fr;q=0.9, da-DK;q=0.8, en;q=0.5")
+	testkit.Equal(t, f.MatchLocale(request), "da")
+	testkit.Equal(t, f.MatchLocale(httptest.NewRequest("GET", "/?lang=EN", nil)), "en")
+	testkit.Equal(t, f.MatchLocale(httptest.NewRequest("GET", "/", nil)), "en")
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.RenderLocalizedTemplate([]string{"/page.tmpl"}, f.MatchLocale(request), w, "Bob"))
+	testkit.Assert(t, strings.HasPrefix(w.Body.String(), "Hej, Bob!"))
+
+	// catalogs follow their files
+	f.AddFile(write("en2.json", `{"hello": "Hi, %v!"}`), "/i18n/en.json")
+	testkit.Equal(t, f.Translate("en", "hello", "Bob"), "Hi, Bob!")
+}
+
+func TestBufferedRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("page.tmpl", `<html>lots of output{{if .Fail}}{{index .List 5}}{{end}}</html>`), "/page.tmpl")
+
+	// a late error sends a clean 500 without the partial page
+	w := httptest.NewRecorder()
+	testkit.Error(t, f.RenderTemplate([]string{"/page.tmpl"}, w, map[string]interface{}{"Fail": true, "List": []int{}}))
+	testkit.Equal(t, w.Code, http.StatusInternalServerError)
+	testkit.Assert(t, !strings.Contains(w.Body.String(), "lots of output"))
+
+	output, err := f.RenderToBytes([]string{"/page.tmpl"}, map[string]interface{}{"Fail": false})
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(output), "<html>lots of output</html>")
+	_, err = f.RenderToBytes([]string{"/page.tmpl"}, map[string]interface{}{"Fail": true, "List": []int{}})
+	testkit.Error(t, err)
+}
+
+func TestRenderTemplateTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("page.tmpl", `{{define "title"}}T{{end}}<p>{{.}}</p>`), "/page.tmpl")
+
+	var buf bytes.Buffer
+	testkit.NoError(t, f.RenderTemplateTo([]string{"/page.tmpl"}, &buf, "hi"))
+	testkit.Equal(t, buf.String(), "<p>hi</p>")
+
+	buf.Reset()
+	testkit.NoError(t, f.RenderNamedTemplateTo([]string{"/page.tmpl"}, "title", &buf, nil))
+	testkit.Equal(t, buf.String(), "T")
+
+	buf.Reset()
+	testkit.Error(t, f.RenderNamedTemplateTo([]string{"/page.tmpl"}, "missing", &buf, nil))
+	testkit.Equal(t, buf.Len(), 0)
+}
+
+func TestCheckTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("layout.tmpl", `<body>{{template "content" .}}</body>`), "/layout.tmpl")
+	f.AddFile(write("page.tmpl", `{{define "content"}}hi{{end}}`), "/page.tmpl")
+	testkit.NoError(t, f.CheckTemplates([][]string{{"/page.tmpl", "/layout.tmpl"}}))
+
+	f.AddFile(write("broken.html", `{{if}}`), "/broken.html")
+	err = f.CheckTemplates(nil)
+	errs, ok := err.(TemplateErrors)
+	testkit.Assert(t, ok)
+	testkit.Equal(t, len(errs), 2)
+	var preprocessErr *PreprocessError
+	testkit.Assert(t, errors.As(errs[0], &preprocessErr))
+	testkit.Equal(t, preprocessErr.Path, "/broken.html")
+	testkit.Equal(t, errs[1].Error(), `/layout.tmpl: template "/layout.tmpl" calls undefined template "content"`)
+}
+
+func TestRenderFragment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("layout.tmpl", `<body>{{block "content" .}}{{end}}</body>`), "/layout.tmpl")
+	f.AddFile(write("page.tmpl", `{{define "content"}}<ul>{{block "items" .}}{{range .}}<li>{{.}}</li>{{end}}{{end}}</ul>{{end}}`), "/page.tmpl")
+	set := []string{"/page.tmpl", "/layout.tmpl"}
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.RenderFragment(set, "items", w, []string{"a", "b"}))
+	testkit.Equal(t, w.Body.String(), "<li>a</li><li>b</li>")
+
+	w = httptest.NewRecorder()
+	testkit.NoError(t, f.RenderFragment(set, "content", w, []string{"a"}))
+	testkit.Equal(t, w.Body.String(), "<ul><li>a</li></ul>")
+
+	w = httptest.NewRecorder()
+	testkit.Error(t, f.RenderFragment(set, "missing", w, nil))
+	testkit.Equal(t, w.Code, http.StatusInternalServerError)
+}
+
+func TestTemplateCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("layout.tmpl", `<main>{{template "content" .}}</main>`), "/templates/layout.tmpl")
+	f.AddFile(write("home.tmpl", `{{define "content"}}home{{end}}`), "/templates/home.tmpl")
+	f.AddFile(write("about.tmpl", `{{define "content"}}about{{end}}`), "/templates/about.tmpl")
+	home := []string{"/templates/layout.tmpl", "/templates/home.tmpl"}
+	about := []string{"/templates/layout.tmpl", "/templates/about.tmpl"}
+
+	homeTmpl, err := f.GetTemplate(home)
+	testkit.NoError(t, err)
+	aboutTmpl, err := f.GetTemplate(about)
+	testkit.NoError(t, err)
+
+	// unrelated files leave the sets cached
+	f.AddFile(write("site.css", `body{}`), "/css/site.css")
+	cached, err := f.GetTemplate(home)
+	testkit.NoError(t, err)
+	testkit.Equal(t, cached == homeTmpl, true)
+
+	// changing a member evicts only the sets containing it
+	f.AddFile(write("about2.tmpl", `{{define "content"}}about us{{end}}`), "/templates/about.tmpl")
+	cached, err = f.GetTemplate(home)
+	testkit.NoError(t, err)
+	testkit.Equal(t, cached == homeTmpl, true)
+	cached, err = f.GetTemplate(about)
+	testkit.NoError(t, err)
+	testkit.Equal(t, cached == aboutTmpl, false)
+	output, err := f.RenderNamedTemplateString(about, "/templates/layout.tmpl", nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<main>about us</main>")
+
+	// a shared member evicts both
+	f.AddFile(write("layout2.tmpl", `<div>{{template "content" .}}</div>`), "/templates/layout.tmpl")
+	output, err = f.RenderNamedTemplateString(home, "/templates/layout.tmpl", nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<div>home</div>")
+
+	// sets parsed through an alias are evicted when its target changes
+	testkit.NoError(t, f.Alias("/templates/index.tmpl", "/templates/home.tmpl"))
+	index := []string{"/templates/layout.tmpl", "/templates/index.tmpl"}
+	output, err = f.RenderNamedTemplateString(index, "/templates/layout.tmpl", nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<div>home</div>")
+	f.AddFile(write("home2.tmpl", `{{define "content"}}welcome{{end}}`), "/templates/home.tmpl")
+	output, err = f.RenderNamedTemplateString(index, "/templates/layout.tmpl", nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<div>welcome</div>")
+}
+
+func TestConcurrentGetTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("page.tmpl", `<p>{{.}}</p>`), "/templates/page.tmpl")
+
+	// concurrent parses of the same set, while funcs change, all succeed
+	templates := make([]interface{}, 20)
+	var wg sync.WaitGroup
+	for i := range templates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tmpl, err := f.GetTemplate([]string{"/templates/page.tmpl"})
+			if err == nil {
+				templates[i] = tmpl
+			}
+			f.SetTemplateFunc("n"+strconv.Itoa(i%2), func() int { return i })
+		}(i)
+	}
+	wg.Wait()
+	for _, tmpl := range templates {
+		testkit.Assert(t, tmpl != nil)
+	}
+
+	tmpl, err := f.GetTemplate([]string{"/templates/page.tmpl"})
+	testkit.NoError(t, err)
+	cached, err := f.GetTemplate([]string{"/templates/page.tmpl"})
+	testkit.NoError(t, err)
+	testkit.Equal(t, cached == tmpl, true)
+}
+
+func TestDefineTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("article.html", `{{define "content"}}<article>{{.}}</article>{{end}}`), "/t/article.html")
+	f.AddFile(write("layout.html", `<main>{{template "content" .}}</main>`), "/t/layout.html")
+	f.AddFile(write("templates.json", `{"page": ["/t/article.html", "/t/layout.html"], "empty": []}`), "/t/templates.json")
+	f.DefineTemplate("article", "/t/article.html", "/t/layout.html")
+
+	output, err := f.RenderString("article", "Hi")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<main><article>Hi</article></main>")
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.Render("article", w, "Hi"))
+	testkit.Equal(t, w.Body.String(), "<main><article>Hi</article></main>")
+
+	_, err = f.RenderString("missing", nil)
+	testkit.Assert(t, err != nil)
+
+	// definitions from an asset
+	testkit.Assert(t, f.LoadTemplateDefinitions("/t/templates.json") != nil)
+	f.AddFile(write("templates2.json", `{"page": ["/t/article.html", "/t/layout.html"]}`), "/t/templates.json")
+	testkit.NoError(t, f.LoadTemplateDefinitions("/t/templates.json"))
+	output, err = f.RenderString("page", "Hello")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<main><article>Hello</article></main>")
+}
+
+func TestRenderTemplateCtx(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	type userKey struct{}
+	f := NewAssets("/a/")
+	f.SetTemplateFunc("user", func(ctx context.Context) string {
+		user, _ := ctx.Value(userKey{}).(string)
+		return user
+	})
+	f.AddFile(write("page.tmpl", `<p>{{.}} {{user context}}</p>`), "/templates/page.tmpl")
+	page := []string{"/templates/page.tmpl"}
+
+	for _, user := range []string{"ann", "bob"} {
+		w := httptest.NewRecorder()
+		ctx := context.WithValue(context.Background(), userKey{}, user)
+		testkit.NoError(t, f.RenderTemplateCtx(ctx, page, w, "Hi"))
+		testkit.Equal(t, w.Body.String(), "<p>Hi "+user+"</p>")
+	}
+
+	// the plain render has a background context
+	output, err := f.RenderTemplateString(page, "Hi")
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<p>Hi </p>")
+
+	// nothing is written for a cancelled request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	testkit.Equal(t, f.RenderTemplateCtx(ctx, page, w, "Hi"), context.Canceled)
+	testkit.Equal(t, w.Body.Len(), 0)
+}
+
+func TestRenderStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("404.tmpl", `<h1>{{.}} not found</h1>`), "/templates/404.tmpl")
+	f.AddFile(write("empty.tmpl", ``), "/templates/empty.tmpl")
+	f.AddFile(write("broken.tmpl", `{{.Missing.Field}}`), "/templates/broken.tmpl")
+
+	w := httptest.NewRecorder()
+	testkit.NoError(t, f.RenderStatus(http.StatusNotFound, http.Header{"Cache-Control": {"no-store"}}, []string{"/templates/404.tmpl"}, w, "/x"))
+	testkit.Equal(t, w.Code, http.StatusNotFound)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), "no-store")
+	testkit.Equal(t, w.Body.String(), "<h1>/x not found</h1>")
+
+	// the status is sent for empty output too
+	w = httptest.NewRecorder()
+	testkit.NoError(t, f.RenderStatus(http.StatusGone, nil, []string{"/templates/empty.tmpl"}, w, nil))
+	testkit.Equal(t, w.Code, http.StatusGone)
+
+	// failures send the error page with its status and without the headers
+	w = httptest.NewRecorder()
+	testkit.Assert(t, f.RenderStatus(http.StatusNotFound, http.Header{"Cache-Control": {"no-store"}}, []string{"/templates/broken.tmpl"}, w, 1) != nil)
+	testkit.Equal(t, w.Code, http.StatusInternalServerError)
+	testkit.Equal(t, w.Header().Get("Cache-Control"), "")
+}
+
+func TestTemplateTrimWhitespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("list.tmpl", "<ul>\n  {{range .}}\n  <li>{{.}} items</li>\n  {{end}}\n</ul>\n<pre>\n  <b>kept</b>\n</pre>\n<p>a <b>b</b></p>"), "/templates/list.tmpl")
+	list := []string{"/templates/list.tmpl"}
+
+	output, err := f.RenderTemplateString(list, []int{1, 2})
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(output, "<ul>\n  \n  <li>1 items</li>"))
+
+	f.SetTemplateTrimWhitespace("/templates/list.tmpl", true)
+	output, err = f.RenderTemplateString(list, []int{1, 2})
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<ul><li>1 items</li><li>2 items</li></ul><pre>\n  <b>kept</b>\n</pre><p>a <b>b</b></p>")
+}
+
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes = append(r.flushes, r.Body.String())
+}
+
+func TestRenderTemplateStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("page.tmpl", `<head></head>{{flush}}<p>{{call .Slow}}</p>`), "/templates/page.tmpl")
+	page := []string{"/templates/page.tmpl"}
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	data := map[string]interface{}{"Slow": func() (string, error) {
+		// the head has been sent by the time the slow part renders
+		testkit.Equal(t, w.flushes, []string{"<head></head>"})
+		return "done", nil
+	}}
+	testkit.NoError(t, f.RenderTemplateStream(context.Background(), page, w, data))
+	testkit.Equal(t, w.Body.String(), "<head></head><p>done</p>")
+
+	// flush does nothing in buffered renders
+	output, err := f.RenderTemplateString(page, map[string]interface{}{"Slow": func() string { return "done" }})
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "<head></head><p>done</p>")
+
+	// errors after a flush can't send an error page
+	w = &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	err = f.RenderTemplateStream(context.Background(), page, w, map[string]interface{}{"Slow": func() (string, error) { return "", errors.New("backend down") }})
+	testkit.Assert(t, err != nil)
+	testkit.Equal(t, w.Code, http.StatusOK)
+	testkit.Equal(t, w.Body.String(), "<head></head>")
+}
+
+func TestSafeFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddStandardFuncs()
+	f.SetTemplateFunc("icon", func(name string) template.HTML { return template.HTML("<i class=" + name + "></i>") })
+	f.AddFile(write("page.tmpl", `{{jscode "x"}}`), "/templates/page.tmpl")
+	unsafe := f.UnsafeFuncs()
+	testkit.Assert(t, len(unsafe) > 3)
+
+	f.SafeFuncs("icon")
+	unsafe = f.UnsafeFuncs()
+	testkit.Assert(t, len(unsafe) > 1)
+	for _, name := range unsafe {
+		testkit.Assert(t, name != "jscode" && name != "safehtml")
+	}
+
+	// templates using removed funcs fail
+	_, err = f.RenderTemplateString([]string{"/templates/page.tmpl"}, nil)
+	testkit.Assert(t, err != nil)
+
+	// allowed and escaped funcs can still be registered
+	f.SetTemplateFunc("icon", func(name string) template.HTML { return "" })
+	f.SetTemplateFunc("upper", strings.ToUpper)
+	defer func() {
+		testkit.Assert(t, recover() != nil)
+	}()
+	f.SetTemplateFunc("raw", func(s string) template.HTML { return template.HTML(s) })
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+	write("site.css", `a{}`)
+	f := NewAssets("/a/")
+	eventually := func(virtualPath string, content string) {
+		for i := 0; i < 200; i++ {
+			if file, err := f.Get(virtualPath); err == nil && string(file.Content) == content {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%v never became %q", virtualPath, content)
+	}
+
+	testkit.NoError(t, f.AddDirectory(dir, "/css/"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testkit.NoError(t, f.Watch(ctx))
+
+	file, err := f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "a{}")
+
+	// changed files are reloaded
+	write("site.css", `b{}`)
+	eventually("/css/site.css", "b{}")
+
+	// created files and directories are added
+	write("new.css", `c{}`)
+	eventually("/css/new.css", "c{}")
+	testkit.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	write("sub/d.css", `d{}`)
+	eventually("/css/sub/d.css", "d{}")
+}
+
+func TestLiveReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("page.tmpl", `{{livereload}}`), "/templates/page.tmpl")
+	f.AddFile(write("site.css", `a{}`), "/css/site.css")
+	output, err := f.RenderTemplateString([]string{"/templates/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "")
+
+	f.Mode = ModeDevelopment
+	output, err = f.RenderTemplateString([]string{"/templates/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(output, `new EventSource("/a/_livereload")`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testkit.NoError(t, f.Watch(ctx))
+	server := httptest.NewServer(f)
+	defer server.Close()
+	response, err := http.Get(server.URL + "/a/_livereload")
+	testkit.NoError(t, err)
+	defer response.Body.Close()
+	testkit.Equal(t, response.Header.Get("Content-Type"), "text/event-stream")
+
+	// changes are sent to the browser
+	write("site.css", `b{}`)
+	event := make([]byte, 64)
+	n, err := response.Body.Read(event)
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(event[:n]), "data: /css/site.css\n\n")
+}
+
+func TestSetDevMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.Mode = ModeProduction
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", Stage: StageMinify, Processor: ProductionOnly(func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return bytes.ToUpper(content), nil
+	})})
+	f.AddFile(write("a.txt", "a"), "/a.txt")
+	f.AddFile(write("page.tmpl", `{{development}}`), "/templates/page.tmpl")
+	get := func() (string, string) {
+		file, err := f.Get("/a.txt")
+		testkit.NoError(t, err)
+		w := httptest.NewRecorder()
+		f.Serve(f.fileURL(file), w, nil)
+		return string(file.Content), w.Header().Get("Cache-Control")
+	}
+
+	content, cacheControl := get()
+	testkit.Equal(t, content, "A")
+	testkit.Assert(t, cacheControl != "no-cache")
+
+	// switching processes files again and changes how they're served
+	f.SetDevMode(true)
+	content, cacheControl = get()
+	testkit.Equal(t, content, "a")
+	testkit.Equal(t, cacheControl, "no-cache")
+	output, err := f.RenderTemplateString([]string{"/templates/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	testkit.Equal(t, output, "true")
+
+	f.SetDevMode(false)
+	content, _ = get()
+	testkit.Equal(t, content, "A")
+	testkit.Equal(t, f.Mode, ModeProduction)
+}
+
+func TestSiteSetDevMode(t *testing.T) {
+	site := NewSite(true, "/a/")
+	render := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		site.Assets.renderError(w, httptest.NewRequest("GET", "/a/x", nil), http.StatusInternalServerError, errors.New("broken"))
+		return w
+	}
+	testkit.Equal(t, render().Header().Get("Content-Type"), "text/html; charset=utf-8")
+
+	// dev error pages are not served after switching to production
+	site.Assets.SetDevMode(false)
+	w := render()
+	testkit.Equal(t, w.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	testkit.Equal(t, w.Body.String(), "broken\n")
+}
+
+func TestDebugHandler(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddFile("testassets/css/test.css", "/css/test.css")
+	f.AddContent("/data.txt", []byte("data"), FileOptions{})
+	testkit.NoError(t, f.Alias("/css/alias.css", "/css/test.css"))
+	_, err := f.Get("/data.txt")
+	testkit.NoError(t, err)
+
+	infos := f.AssetInfos()
+	testkit.Equal(t, len(infos), 3)
+	testkit.Equal(t, infos[0].VirtualPath, "/css/alias.css")
+	testkit.Equal(t, infos[0].AliasOf, "/css/test.css")
+	testkit.Equal(t, infos[1].Path, "testassets/css/test.css")
+	testkit.Equal(t, infos[1].Loaded, false)
+	testkit.Assert(t, len(infos[1].Preprocessors) > 0)
+	testkit.Equal(t, infos[2].Loaded, true)
+	testkit.Equal(t, infos[2].Size, 4)
+
+	w := httptest.NewRecorder()
+	f.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug?format=json", nil))
+	var decoded []AssetInfo
+	testkit.NoError(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+	testkit.Equal(t, len(decoded), 3)
+
+	w = httptest.NewRecorder()
+	f.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug", nil))
+	testkit.Assert(t, strings.Contains(w.Body.String(), "web.AssetCssPreprocessor"))
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		testkit.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		return filepath.Join(dir, name)
+	}
+
+	f := NewAssets("/a/")
+	f.AddFile(write("logo.png", "png"), "/images/logo.png")
+	f.AddFile(write("site.css", `a{background:url(../images/logo.png)} b{background:url(../images/logo.pnj)} c{background:url(data:image/png;base64,x)}`), "/css/site.css")
+	f.AddFile(write("page.tmpl", `{{asset "/images/logo.png"}}{{stylesheet "/css/site.css"}}{{script "/js/app.js"}}{{asset_preload "/images/logo.png" "/fonts/x.woff2"}}{{asset .Dynamic}}`), "/templates/page.tmpl")
+	f.LoadManifest(Manifest{"/js/app.js": {URL: "https://cdn/app.js"}})
+
+	err = f.Verify()
+	errs, ok := err.(TemplateErrors)
+	testkit.Assert(t, ok)
+	testkit.Equal(t, len(errs), 2)
+	testkit.Equal(t, errs[0].Error(), "/css/site.css: ../images/logo.pnj is not a registered asset")
+	testkit.Equal(t, errs[1].Error(), "/templates/page.tmpl: /fonts/x.woff2 is not a registered asset")
+
+	f.AddFile(write("x.woff2", "font"), "/fonts/x.woff2")
+	f.AddFile(write("site2.css", `a{background:url(../images/logo.png)}`), "/css/site.css")
+	testkit.NoError(t, f.Verify())
+}
+
+func TestDiffManifests(t *testing.T) {
+	old := Manifest{
+		"/css/site.css": {Hash: "a", Size: 100},
+		"/js/old.js":    {Hash: "b", Size: 40},
+		"/img/logo.png": {Hash: "c", Size: 500},
+	}
+	new := Manifest{
+		"/css/site.css": {Hash: "d", Size: 120},
+		"/js/app.js":    {Hash: "e", Size: 60},
+		"/img/logo.png": {Hash: "c", Size: 500},
+	}
+
+	diff := DiffManifests(old, new)
+	testkit.Equal(t, len(diff.Added), 1)
+	testkit.Equal(t, diff.Added[0].VirtualPath, "/js/app.js")
+	testkit.Equal(t, len(diff.Removed), 1)
+	testkit.Equal(t, diff.Removed[0].SizeDelta(), -40)
+	testkit.Equal(t, len(diff.Changed), 1)
+	testkit.Equal(t, diff.Changed[0].SizeDelta(), 20)
+	testkit.Equal(t, diff.SizeDelta(), 40)
+	testkit.Assert(t, strings.Contains(diff.Markdown(), "| ~ | `/css/site.css` | 100 | 120 | +20 |"))
+	testkit.Assert(t, strings.Contains(diff.String(), "total"))
+	testkit.Assert(t, DiffManifests(new, new).Empty())
+}
+
+func TestLoadErrorStub(t *testing.T) {
+	failing := func(assets *Assets, path string, content []byte) ([]byte, error) {
+		return nil, errors.New(`unexpected "}"`)
+	}
+	f := NewAssets("/a/")
+	f.Mode = ModeDevelopment
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".js", Stage: StageCompile, Processor: failing})
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".css", Stage: StageCompile, Processor: failing})
+	f.AddContent("/js/app.js", []byte("app("), FileOptions{})
+	f.AddContent("/css/site.css", []byte("body{"), FileOptions{})
+
+	tag, err := f.ScriptTag("/js/app.js")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(tag), `<script src="/a/_error/js/app.js"></script>`)
+	w := httptest.NewRecorder()
+	f.Serve("/a/_error/js/app.js", w, nil)
+	testkit.Equal(t, w.Code, http.StatusOK)
+	testkit.Equal(t, w.Body.String(), `console.error("/js/app.js: unexpected \"}\"");`+"\n")
+
+	url, err := f.GetUrl("/css/site.css")
+	testkit.NoError(t, err)
+	w = httptest.NewRecorder()
+	f.Serve(url, w, nil)
+	testkit.Equal(t, w.Header().Get("Content-Type"), "text/css; charset=utf-8")
+	testkit.Assert(t, strings.HasPrefix(w.Body.String(), `body::before{content:"/css/site.css: unexpected \"}\"";`))
+
+	f.SetDevMode(false)
+	_, err = f.GetUrl("/css/site.css")
+	testkit.Error(t, err)
+	w = httptest.NewRecorder()
+	f.Serve("/a/_error/css/site.css", w, nil)
+	testkit.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestProfileLabels(t *testing.T) {
+	f := NewAssets("/a/")
+	labels := make(map[string]string)
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", ContextProcessor: func(ctx *PreprocessContext, content []byte) ([]byte, error) {
+		pprof.ForLabels(ctx.Context, func(key, value string) bool {
+			labels[key] = value
+			return true
+		})
+		return content, nil
+	}})
+	f.AddContent("/notes.txt", []byte("notes"), FileOptions{})
+	_, err := f.Get("/notes.txt")
+	testkit.NoError(t, err)
+	testkit.Equal(t, labels["asset"], "/notes.txt")
+	testkit.Equal(t, labels["stage"], "preprocess")
+	testkit.Assert(t, strings.HasPrefix(labels["preprocessor"], "web.TestProfileLabels"))
+
+	// the labels of the caller are kept
+	f.AddContent("/more.txt", []byte("more"), FileOptions{})
+	testkit.NoError(t, f.loadAll(pprof.WithLabels(context.Background(), pprof.Labels("build", "nightly")), []string{"/more.txt"}))
+	testkit.Equal(t, labels["build"], "nightly")
+	testkit.Equal(t, labels["asset"], "/more.txt")
+}
+
+func TestPreload(t *testing.T) {
+	f := NewAssets("/a/")
+	var lock sync.Mutex
+	var loaded []string
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", Processor: func(assets *Assets, path string, content []byte) ([]byte, error) {
+		lock.Lock()
+		loaded = append(loaded, path)
+		lock.Unlock()
+		return content, nil
+	}})
+	f.AddContent("/critical/a.txt", []byte("a"), FileOptions{})
+	f.AddContent("/critical/b.txt", []byte("b"), FileOptions{})
+	f.AddContent("/later.txt", []byte("later"), FileOptions{})
+	f.AddContent("/page.tmpl", []byte(`{{define "page"}}page{{end}}`), FileOptions{})
+	f.DefineTemplate("page", "/page.tmpl")
+
+	testkit.NoError(t, f.Preload("/critical/*.txt", "/critical/a.txt"))
+	testkit.Equal(t, len(loaded), 2)
+	testkit.Error(t, f.Preload("/missing.txt"))
+
+	testkit.NoError(t, f.PreloadAll())
+	testkit.Equal(t, len(loaded), 3)
+	f.lock.RLock()
+	cached := len(f.templateCache)
+	f.lock.RUnlock()
+	testkit.Equal(t, cached, 1)
+}
+
+type eventRecorder struct {
+	lock   sync.Mutex
+	events []logkit.Event
+}
+
+func (r *eventRecorder) Event(event logkit.Event) {
+	r.lock.Lock()
+	r.events = append(r.events, event)
+	r.lock.Unlock()
+}
+
+func (r *eventRecorder) messages() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var messages []string
+	for _, event := range r.events {
+		if event.Message != "" {
+			messages = append(messages, event.Message)
+		}
+	}
+	return messages
+}
+
+func TestLogOutput(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", Processor: func(assets *Assets, path string, content []byte) ([]byte, error) {
+		if string(content) == "broken" {
+			return nil, errors.New("broken")
+		}
+		return content, nil
+	}})
+	f.AddContent("/notes.txt", []byte("notes"), FileOptions{})
+	f.AddContent("/broken.txt", []byte("broken"), FileOptions{})
+	f.AddContent("/page.tmpl", []byte(`{{asset "/notes.txt"}}`), FileOptions{})
+	_, err := f.Get("/notes.txt")
+	testkit.NoError(t, err)
+
+	recorder := &eventRecorder{}
+	f.SetLogOutput(recorder)
+	_, err = f.RenderTemplateString([]string{"/page.tmpl"}, nil)
+	testkit.NoError(t, err)
+	_, err = f.Get("/broken.txt")
+	testkit.Error(t, err)
+	f.AddContent("/page.tmpl", []byte(`{{asset "/missing.txt"}}`), FileOptions{})
+	f.RenderTemplate([]string{"/page.tmpl"}, httptest.NewRecorder(), nil)
+	testkit.Equal(t, strings.Join(recorder.messages(), ", "), "asset loaded, template parsed, preprocessor failed, templates evicted, asset loaded, template parsed, serving failed")
+
+	f.SetLogOutput(nil)
+	f.AddContent("/page.tmpl", []byte(`page`), FileOptions{})
+	testkit.Equal(t, len(recorder.messages()), 7)
+
+	// preprocessors and size budgets log there too
+	f = NewAssets("/a/")
+	recorder = &eventRecorder{}
+	f.SetLogOutput(recorder)
+	f.AddPreprocessorRule(PreprocessorRule{Extension: ".txt", ContextProcessor: func(ctx *PreprocessContext, content []byte) ([]byte, error) {
+		ctx.Warn("odd notes")
+		return content, nil
+	}})
+	f.AddContent("/notes.txt", []byte("notes"), FileOptions{})
+	f.Budgets = []SizeBudget{{Pattern: "/notes.txt", MaxSize: 1}}
+	_, err = f.BuildAll(context.Background())
+	testkit.NoError(t, err)
+	testkit.Equal(t, strings.Join(recorder.messages(), ", "), "odd notes, asset loaded, size budget exceeded")
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	testkit.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := NewAssets("/a/")
+	f.Mode = ModeProduction
+	f.URLFormat = URLNamedHash
+	f.SourceMaps = SourceMapsStrip
+	f.AddContent("/js/app.js", []byte(strings.Repeat("console.log(1);\n", 100)), FileOptions{})
+	f.AddContent("/js/app.js.map", []byte(`{"version":3}`), FileOptions{ContentType: "application/json"})
+	testkit.NoError(t, f.Export(dir))
+
+	url, err := f.GetUrl("/js/app.js")
+	testkit.NoError(t, err)
+	name := filepath.Join(dir, strings.TrimPrefix(url, "/a/"))
+	content, err := ioutil.ReadFile(name)
+	testkit.NoError(t, err)
+	testkit.Equal(t, len(content), 1600)
+	_, err = os.Stat(name + ".gz")
+	testkit.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	testkit.NoError(t, err)
+	manifest, err := ParseManifest(data)
+	testkit.NoError(t, err)
+	testkit.Equal(t, manifest["/js/app.js"].URL, url)
+	matches, err := filepath.Glob(filepath.Join(dir, "app.js.*.map"))
+	testkit.NoError(t, err)
+	testkit.Equal(t, len(matches), 0)
+}
+
+type memoryStore struct {
+	lock    sync.Mutex
+	objects map[string]ObjectMeta
+}
+
+func (s *memoryStore) Hash(ctx context.Context, key string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.objects[key].Hash, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, content []byte, meta ObjectMeta) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[key] = meta
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	f := NewAssets("/a/")
+	f.Mode = ModeProduction
+	f.URLFormat = URLQuery
+	f.AddContent("/js/app.js", []byte(strings.Repeat("console.log(1);\n", 100)), FileOptions{})
+	f.AddContent("/robots.txt", []byte("x"), FileOptions{CacheControl: "no-cache"})
+	store := &memoryStore{objects: make(map[string]ObjectMeta)}
+
+	report, err := f.Sync(context.Background(), store)
+	testkit.NoError(t, err)
+	testkit.Equal(t, report.Uploaded, []string{"js/app.js", "js/app.js.gz", "robots.txt"})
+	testkit.Equal(t, store.objects["js/app.js.gz"].ContentEncoding, "gzip")
+	testkit.Equal(t, store.objects["js/app.js.gz"].ContentType, store.objects["js/app.js"].ContentType)
+	testkit.Equal(t, store.objects["robots.txt"].CacheControl, "no-cache")
+
+	f.AddContent("/robots.txt", []byte("y"), FileOptions{})
+	report, err = f.Sync(context.Background(), store)
+	testkit.NoError(t, err)
+	testkit.Equal(t, report.Uploaded, []string{"robots.txt"})
+	testkit.Equal(t, report.Skipped, []string{"js/app.js"})
+}
+
+func TestSetPublicURL(t *testing.T) {
+	f := NewAssets("/a/")
+	f.AddContent("/images/logo.png", []byte("png"), FileOptions{})
+	f.AddContent("/css/site.css", []byte("a{background:url(../images/logo.png)}"), FileOptions{})
+	f.LoadManifest(Manifest{"/js/app.js": {URL: "/a/0123"}})
+	logoURL, err := f.GetUrl("/images/logo.png")
+	testkit.NoError(t, err)
+
+	f.SetPublicURL("https://cdn.example.com/a/")
+	testkit.Equal(t, f.PublicURL(), "https://cdn.example.com/a/")
+	cssURL, err := f.GetUrl("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.HasPrefix(cssURL, "https://cdn.example.com/a/"))
+	appURL, err := f.GetUrl("/js/app.js")
+	testkit.NoError(t, err)
+	testkit.Equal(t, appURL, "https://cdn.example.com/a/0123")
+	tag, err := f.StylesheetTag("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.Contains(string(tag), `href="`+cssURL+`"`))
+
+	// content and serving stay at the base url
+	file, err := f.Get("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Equal(t, string(file.Content), "a{background:url("+logoURL+")}")
+	w := httptest.NewRecorder()
+	f.Serve(strings.TrimPrefix(cssURL, "https://cdn.example.com"), w, nil)
+	testkit.Equal(t, w.Code, http.StatusOK)
+
+	f.SetPublicURL("")
+	cssURL, err = f.GetUrl("/css/site.css")
+	testkit.NoError(t, err)
+	testkit.Assert(t, strings.HasPrefix(cssURL, "/a/"))
+}
@@ -128,12 +128,8 @@ func (c *Context) RenderTemplate(templatePath string, data interface{}) error {
 		}
 	}
 
-	templateFiles := []string{templatePath, master}
-	if master == "" {
-		templateFiles = templateFiles[0:1]
-	}
-
-	err := c.Site.Assets.RenderTemplate(templateFiles, c.w, data)
+	assets := c.Site.Assets
+	err := assets.RenderLocalizedTemplate(assets.layoutChain(master, templatePath), assets.MatchLocale(c.Request), c.w, data)
 	if err != nil {
 		fmt.Println("RenderTemplate error: ", err, templatePath, master)
 		return err
@@ -25,7 +25,7 @@ type Site struct {
 	Development           bool
 	DefaultMasterFile     string
 	TemplateDataWrapper   TemplateDataWrapper
-	Assets                Assets
+	Assets                *Assets
 	NotFound              Route
 	ServerError           Route
 	PanicHandler          func(c *Context, err interface{}) bool
@@ -42,7 +42,10 @@ func NewSite(development bool, assetPath string) *Site {
 	site.router.RedirectTrailingSlash = true
 	site.router.RedirectFixedPath = true
 	site.Assets = NewAssets(assetPath)
-	site.AddRoute(Route{Path: assetPath + ":checksum", NoGZip: true, Action: func(c *Context) {
+	if development {
+		site.Assets.Mode = ModeDevelopment
+	}
+	site.AddRoute(Route{Path: assetPath + "*asset", NoGZip: true, Action: func(c *Context) {
 		site.Assets.Serve(c.Request.URL.Path, c.w, c.Request)
 	}})
 